@@ -0,0 +1,180 @@
+// shitlerd - A manager for online Secret Hitler games
+// Copyright (C) 2016-2017 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package accounts contains the registered user accounts
+package accounts
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const minPasswordLength = 6
+const maxPasswordLength = 72
+
+var accountFile = flag.String("accountFile", "", "Path to the JSON file registered accounts are stored in. Accounts are lost on restart if not set.")
+
+// Errors returned when registering or logging in. The messages are the error codes sent to clients.
+var (
+	ErrNameTaken        = errors.New("nametaken")
+	ErrPasswordTooShort = errors.New("passwordtooshort")
+	ErrPasswordTooLong  = errors.New("passwordtoolong")
+	ErrWrongPassword    = errors.New("wrongaccountpassword")
+	ErrWrongToken       = errors.New("wrongaccounttoken")
+)
+
+// Account is a registered user that owns a name across all games
+type Account struct {
+	Name         string       `json:"name"`
+	Token        string       `json:"token"`
+	PasswordHash string       `json:"passwordHash"`
+	Created      time.Time    `json:"created"`
+	Games        []GameRecord `json:"games"`
+	Ratings      Ratings      `json:"ratings"`
+}
+
+var accounts = make(map[string]*Account)
+var lock sync.Mutex
+
+// saveLock makes sure the account file is written in the same order the accounts were serialized
+var saveLock sync.Mutex
+
+// Load loads the accounts from the file given with the -accountFile flag
+func Load() error {
+	if len(*accountFile) == 0 {
+		return nil
+	}
+	data, err := ioutil.ReadFile(*accountFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var list []*Account
+	err = json.Unmarshal(data, &list)
+	if err != nil {
+		return err
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	for _, account := range list {
+		accounts[strings.ToLower(account.Name)] = account
+	}
+	return nil
+}
+
+// Save writes all accounts to the file given with the -accountFile flag.
+// The accounts are only locked while serializing them, not while writing the file.
+func Save() error {
+	if len(*accountFile) == 0 {
+		return nil
+	}
+	saveLock.Lock()
+	defer saveLock.Unlock()
+	lock.Lock()
+	list := make([]*Account, 0, len(accounts))
+	for _, account := range accounts {
+		list = append(list, account)
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(*accountFile, data, 0600)
+}
+
+// Register creates an account for the given name. The name must have been validated by the caller.
+func Register(name, password string) (*Account, error) {
+	if len(password) < minPasswordLength {
+		return nil, ErrPasswordTooShort
+	} else if len(password) > maxPasswordLength {
+		return nil, ErrPasswordTooLong
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	lock.Lock()
+	if _, ok := accounts[strings.ToLower(name)]; ok {
+		lock.Unlock()
+		return nil, ErrNameTaken
+	}
+	account := &Account{
+		Name:         name,
+		Token:        randomString(),
+		PasswordHash: string(hash),
+		Created:      time.Now(),
+	}
+	accounts[strings.ToLower(name)] = account
+	lock.Unlock()
+	return account, Save()
+}
+
+// Get gets the account that owns the given name (case-insensitive)
+func Get(name string) *Account {
+	lock.Lock()
+	defer lock.Unlock()
+	return accounts[strings.ToLower(name)]
+}
+
+// Registered checks if the given name is owned by an account
+func Registered(name string) bool {
+	return Get(name) != nil
+}
+
+// Login finds the account with the given name and checks the password
+func Login(name, password string) (*Account, error) {
+	account := Get(name)
+	if account == nil || bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(password)) != nil {
+		return nil, ErrWrongPassword
+	}
+	return account, nil
+}
+
+// LoginToken finds the account with the given name and checks the account token
+func LoginToken(name, token string) (*Account, error) {
+	account := Get(name)
+	if account == nil || subtle.ConstantTimeCompare([]byte(account.Token), []byte(token)) != 1 {
+		return nil, ErrWrongToken
+	}
+	return account, nil
+}
+
+// Owns checks if this account owns the given name
+func (account *Account) Owns(name string) bool {
+	return account != nil && strings.EqualFold(account.Name, name)
+}
+
+func randomString() string {
+	cs := make([]byte, 32)
+	_, err := rand.Read(cs)
+	if err != nil {
+		panic(err)
+	}
+	return base64.StdEncoding.EncodeToString(cs)
+}
@@ -0,0 +1,161 @@
+// shitlerd - A manager for online Secret Hitler games
+// Copyright (C) 2016-2017 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package accounts contains the registered user accounts
+package accounts
+
+import (
+	"math"
+	"sort"
+)
+
+// InitialRating is the rating of new accounts and guests
+const InitialRating = 1500
+
+const ratingK = 32
+
+// liberalWinRates contains the share of games liberals win with evenly rated teams, by player count.
+// The expected results of rated games are shifted by these to account for the balance of each table size.
+var liberalWinRates = map[int]float64{
+	5:  0.45,
+	6:  0.40,
+	7:  0.45,
+	8:  0.42,
+	9:  0.44,
+	10: 0.40,
+}
+
+// Ratings contains the separate liberal and fascist ratings of an account
+type Ratings struct {
+	Liberal      float64 `json:"liberal"`
+	Fascist      float64 `json:"fascist"`
+	LiberalGames int     `json:"liberalGames"`
+	FascistGames int     `json:"fascistGames"`
+}
+
+// Combined returns the average of the liberal and fascist ratings
+func (r Ratings) Combined() float64 {
+	return (r.Liberal + r.Fascist) / 2
+}
+
+func (account *Account) ratings() Ratings {
+	r := account.Ratings
+	if r.LiberalGames == 0 {
+		r.Liberal = InitialRating
+	}
+	if r.FascistGames == 0 {
+		r.Fascist = InitialRating
+	}
+	return r
+}
+
+// GetRatings returns the ratings of the account
+func (account *Account) GetRatings() Ratings {
+	lock.Lock()
+	defer lock.Unlock()
+	return account.ratings()
+}
+
+func teamRating(team []*Account, fascist bool) float64 {
+	if len(team) == 0 {
+		return InitialRating
+	}
+	var sum float64
+	for _, account := range team {
+		if account == nil {
+			sum += InitialRating
+		} else if fascist {
+			sum += account.ratings().Fascist
+		} else {
+			sum += account.ratings().Liberal
+		}
+	}
+	return sum / float64(len(team))
+}
+
+// RateGame updates the ratings of the players of a finished game. Nil accounts are guests, who are counted
+// with the initial rating but not updated. Every member of a team gains or loses the same amount.
+// The accounts aren't saved automatically.
+func RateGame(liberals, fascists []*Account, liberalsWon bool) {
+	lock.Lock()
+	defer lock.Unlock()
+	liberal := teamRating(liberals, false)
+	fascist := teamRating(fascists, true)
+	offset := 0.0
+	if rate, ok := liberalWinRates[len(liberals)+len(fascists)]; ok {
+		offset = 400 * math.Log10(rate/(1-rate))
+	}
+	expected := 1 / (1 + math.Pow(10, (fascist-liberal-offset)/400))
+	result := 0.0
+	if liberalsWon {
+		result = 1
+	}
+	delta := ratingK * (result - expected)
+	for _, account := range liberals {
+		if account != nil {
+			r := account.ratings()
+			account.Ratings.Liberal = r.Liberal + delta
+			account.Ratings.LiberalGames++
+		}
+	}
+	for _, account := range fascists {
+		if account != nil {
+			r := account.ratings()
+			account.Ratings.Fascist = r.Fascist - delta
+			account.Ratings.FascistGames++
+		}
+	}
+}
+
+// LeaderboardEntry is a single account in the leaderboard
+type LeaderboardEntry struct {
+	Name string `json:"name"`
+	Ratings
+	Combined float64 `json:"combined"`
+}
+
+// Leaderboard returns the accounts that have played rated games sorted by the given rating
+// (liberal, fascist or combined). At most limit entries are returned.
+func Leaderboard(by string, limit int) []LeaderboardEntry {
+	lock.Lock()
+	entries := make([]LeaderboardEntry, 0, len(accounts))
+	for _, account := range accounts {
+		r := account.ratings()
+		if r.LiberalGames+r.FascistGames == 0 {
+			continue
+		}
+		entries = append(entries, LeaderboardEntry{Name: account.Name, Ratings: r, Combined: r.Combined()})
+	}
+	lock.Unlock()
+
+	rating := func(entry LeaderboardEntry) float64 {
+		switch by {
+		case "liberal":
+			return entry.Liberal
+		case "fascist":
+			return entry.Fascist
+		default:
+			return entry.Combined
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return rating(entries[i]) > rating(entries[j])
+	})
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
@@ -0,0 +1,116 @@
+// shitlerd - A manager for online Secret Hitler games
+// Copyright (C) 2016-2017 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package accounts contains the registered user accounts
+package accounts
+
+import (
+	"time"
+)
+
+// GameRecord is the result of a single finished game from the point of view of one player
+type GameRecord struct {
+	Game            string    `json:"game"`
+	Time            time.Time `json:"time"`
+	Players         int       `json:"players"`
+	Role            string    `json:"role"`
+	Won             bool      `json:"won"`
+	Reason          string    `json:"reason"`
+	President       int       `json:"president"`
+	Chancellor      int       `json:"chancellor"`
+	LiberalPolicies int       `json:"liberalPolicies"`
+	FascistPolicies int       `json:"fascistPolicies"`
+	Executed        []string  `json:"executed,omitempty"`
+	Investigated    []string  `json:"investigated,omitempty"`
+	WasExecuted     bool      `json:"wasExecuted"`
+}
+
+// WinStats contains the number of games played and won
+type WinStats struct {
+	Games   int     `json:"games"`
+	Wins    int     `json:"wins"`
+	WinRate float64 `json:"winRate"`
+}
+
+func (ws *WinStats) add(won bool) {
+	ws.Games++
+	if won {
+		ws.Wins++
+	}
+	ws.WinRate = float64(ws.Wins) / float64(ws.Games)
+}
+
+// Stats contains the aggregated statistics of all games played by an account
+type Stats struct {
+	Name            string               `json:"name"`
+	Ratings         Ratings              `json:"ratings"`
+	Total           WinStats             `json:"total"`
+	Roles           map[string]*WinStats `json:"roles"`
+	PlayerCounts    map[int]*WinStats    `json:"playerCounts"`
+	Reasons         map[string]int       `json:"reasons"`
+	President       int                  `json:"president"`
+	Chancellor      int                  `json:"chancellor"`
+	LiberalPolicies int                  `json:"liberalPolicies"`
+	FascistPolicies int                  `json:"fascistPolicies"`
+	Executions      int                  `json:"executions"`
+	Investigations  int                  `json:"investigations"`
+	TimesExecuted   int                  `json:"timesExecuted"`
+	Games           []GameRecord         `json:"games"`
+}
+
+// AddGame stores the given game record in the account. The accounts aren't saved automatically.
+func (account *Account) AddGame(record GameRecord) {
+	lock.Lock()
+	defer lock.Unlock()
+	account.Games = append(account.Games, record)
+}
+
+// Stats aggregates the game records of the account
+func (account *Account) Stats() Stats {
+	lock.Lock()
+	defer lock.Unlock()
+	stats := Stats{
+		Name:         account.Name,
+		Ratings:      account.ratings(),
+		Roles:        make(map[string]*WinStats),
+		PlayerCounts: make(map[int]*WinStats),
+		Reasons:      make(map[string]int),
+		Games:        make([]GameRecord, len(account.Games)),
+	}
+	copy(stats.Games, account.Games)
+	for _, record := range account.Games {
+		stats.Total.add(record.Won)
+		if _, ok := stats.Roles[record.Role]; !ok {
+			stats.Roles[record.Role] = &WinStats{}
+		}
+		stats.Roles[record.Role].add(record.Won)
+		if _, ok := stats.PlayerCounts[record.Players]; !ok {
+			stats.PlayerCounts[record.Players] = &WinStats{}
+		}
+		stats.PlayerCounts[record.Players].add(record.Won)
+		stats.Reasons[record.Reason]++
+		stats.President += record.President
+		stats.Chancellor += record.Chancellor
+		stats.LiberalPolicies += record.LiberalPolicies
+		stats.FascistPolicies += record.FascistPolicies
+		stats.Executions += len(record.Executed)
+		stats.Investigations += len(record.Investigated)
+		if record.WasExecuted {
+			stats.TimesExecuted++
+		}
+	}
+	return stats
+}
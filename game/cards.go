@@ -89,6 +89,17 @@ func (cards *Cards) Peek() []Card {
 	return cards.Deck[0:3]
 }
 
+// RemoveCard removes one card of the given type from the deck
+func (cards *Cards) RemoveCard(card Card) bool {
+	for i, c := range cards.Deck {
+		if c == card {
+			cards.Deck = append(cards.Deck[:i], cards.Deck[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
 // ResetDiscarded moves all discarded cards back to the deck
 func (cards *Cards) ResetDiscarded() {
 	for i := range cards.Discarded {
@@ -0,0 +1,169 @@
+// shitlerd - A manager for online Secret Hitler games
+// Copyright (C) 2016-2017 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package game contains the game management code
+package game
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Chat limits
+const (
+	ChatMaxLength  = 300
+	ChatRateCount  = 5
+	ChatRatePeriod = 10 * time.Second
+)
+
+// Channel is a chat channel
+type Channel string
+
+// The possible chat channels
+const (
+	ChannelLobby     Channel = "lobby"
+	ChannelTable     Channel = "table"
+	ChannelGhost     Channel = "ghost"
+	ChannelSpectator Channel = "spectator"
+	ChannelSystem    Channel = "system"
+)
+
+// ChatLock decides who can't chat during the legislative session
+type ChatLock string
+
+// The possible chat lock options
+const (
+	ChatLockOff        ChatLock = ""
+	ChatLockAll        ChatLock = "all"
+	ChatLockGovernment ChatLock = "government"
+)
+
+// Legislating checks if the president or chancellor is currently discarding cards
+func (game *Game) Legislating() bool {
+	return game.State == ActDiscardPresident || game.State == ActDiscardChancellor
+}
+
+// ChatLocked checks if the given player is currently not allowed to chat at the table
+func (game *Game) ChatLocked(player *Player) bool {
+	if game.Ended || !game.Legislating() {
+		return false
+	}
+	switch game.Settings.ChatLock {
+	case ChatLockAll:
+		return true
+	case ChatLockGovernment:
+		return player == game.President || player == game.Chancellor
+	default:
+		return false
+	}
+}
+
+// SetState changes the current state of the game. If the chat lock is enabled,
+// a system message is sent when the legislative session starts and ends.
+func (game *Game) SetState(state Action) {
+	wasLegislating := game.Legislating()
+	game.State = state
+	if game.Settings.ChatLock == ChatLockOff || wasLegislating == game.Legislating() {
+		return
+	}
+	if wasLegislating {
+		game.SystemMessage("The legislative session has ended, chat is unlocked")
+	} else if game.Settings.ChatLock == ChatLockGovernment {
+		game.SystemMessage("The legislative session has started, chat is locked for the president and the chancellor")
+	} else {
+		game.SystemMessage("The legislative session has started, chat is locked")
+	}
+}
+
+// ChatChannel gets the channel chat messages from the given player are sent to
+func (game *Game) ChatChannel(player *Player) Channel {
+	switch {
+	case player.Spectator && game.Settings.SpectatorGhostChat:
+		return ChannelGhost
+	case player.Spectator:
+		return ChannelSpectator
+	case !game.Started:
+		return ChannelLobby
+	case game.Ended:
+		return ChannelTable
+	case !player.Alive:
+		return ChannelGhost
+	default:
+		return ChannelTable
+	}
+}
+
+// Chat is called when a player or spectator sends a chat message.
+// Messages from muted players and messages that are empty, too long, sent too quickly or blocked by the filter are rejected.
+func (player *Player) Chat(message string) {
+	message = strings.TrimSpace(message)
+	code := player.checkChat(message)
+	if len(code) == 0 {
+		var ok bool
+		message, ok = filterMessage(player.Name, message)
+		if !ok {
+			code = "messageblocked"
+		}
+	}
+	if len(code) > 0 {
+		player.Game.debugln(player.Name, "tried to send a chat message, but it was rejected:", code)
+		player.reject(TypeChat, code)
+		return
+	}
+	player.Game.SendChat(player.Game.ChatChannel(player), player.Name, message)
+}
+
+func (player *Player) checkChat(message string) string {
+	if player.Muted {
+		return "muted"
+	} else if player.Game.ChatChannel(player) == ChannelTable && player.Game.ChatLocked(player) {
+		return "chatlocked"
+	} else if len(message) == 0 {
+		return "emptymessage"
+	} else if utf8.RuneCountInString(message) > ChatMaxLength {
+		return "messagetoolong"
+	}
+	now := time.Now()
+	recent := player.chatTimes[:0]
+	for _, sent := range player.chatTimes {
+		if now.Sub(sent) < ChatRatePeriod {
+			recent = append(recent, sent)
+		}
+	}
+	player.chatTimes = recent
+	if len(player.chatTimes) >= ChatRateCount {
+		return "ratelimited"
+	}
+	player.chatTimes = append(player.chatTimes, now)
+	return ""
+}
+
+// SendChat sends a chat message to the given channel. The visibility rules decide who receives the message.
+func (game *Game) SendChat(channel Channel, sender, message string) {
+	game.chatCounter++
+	chat := Chat{Type: TypeChat, ID: game.chatCounter, Time: time.Now().Unix(), Channel: channel, Sender: sender, Message: message}
+	game.Broadcast(chat)
+	game.logChat(chat)
+	if game.Room != nil && (channel == ChannelLobby || channel == ChannelTable) {
+		game.Room.AddChat(chat)
+	}
+}
+
+// SystemMessage sends a message from the server to the system channel
+func (game *Game) SystemMessage(message string) {
+	game.SendChat(ChannelSystem, "", message)
+}
@@ -0,0 +1,291 @@
+// shitlerd - A manager for online Secret Hitler games
+// Copyright (C) 2016-2017 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package game contains the game management code
+package game
+
+import (
+	"strings"
+)
+
+// Government is a single elected government and its legislative session.
+// The real hands are only revealed when the game ends.
+type Government struct {
+	Index           int    `json:"index"`
+	President       string `json:"president"`
+	Chancellor      string `json:"chancellor"`
+	Drawn           []Card `json:"drawn,omitempty"`
+	Received        []Card `json:"received,omitempty"`
+	Enacted         Card   `json:"enacted,omitempty"`
+	Vetoed          bool   `json:"vetoed"`
+	PresidentClaim  []Card `json:"presidentClaim"`
+	ChancellorClaim []Card `json:"chancellorClaim"`
+	Contradiction   bool   `json:"contradiction"`
+}
+
+// Finished checks if the legislative session of the government is over
+func (gov *Government) Finished() bool {
+	return len(gov.Enacted) > 0 || gov.Vetoed
+}
+
+// CurrentGovernment gets the latest elected government, or nil if no government has been elected yet
+func (game *Game) CurrentGovernment() *Government {
+	if len(game.Governments) == 0 {
+		return nil
+	}
+	return game.Governments[len(game.Governments)-1]
+}
+
+// PublicGovernments returns copies of all governments with the real hands removed unless the game has ended
+func (game *Game) PublicGovernments() []Government {
+	govs := make([]Government, len(game.Governments))
+	for i, gov := range game.Governments {
+		govs[i] = *gov
+		if !game.Ended {
+			govs[i].Drawn = nil
+			govs[i].Received = nil
+		}
+	}
+	return govs
+}
+
+func (game *Game) startGovernment() {
+	game.Governments = append(game.Governments, &Government{
+		Index:      len(game.Governments),
+		President:  game.President.Name,
+		Chancellor: game.Chancellor.Name,
+		Drawn:      copyCards(game.Discarding),
+	})
+}
+
+func copyCards(cards []Card) []Card {
+	return append([]Card{}, cards...)
+}
+
+// ParseCards parses a claimed hand. The hand can be a comma-separated string of card names
+// (arrays of card names are joined with commas when decoding) or a string of card initials (e.g. "FFL").
+func ParseCards(hand string) ([]Card, bool) {
+	var parts []string
+	if strings.Contains(hand, ",") {
+		parts = strings.Split(hand, ",")
+	} else {
+		parts = strings.Split(hand, "")
+	}
+	cards := make([]Card, len(parts))
+	for i, part := range parts {
+		switch strings.ToLower(strings.TrimSpace(part)) {
+		case "l", string(CardLiberal):
+			cards[i] = CardLiberal
+		case "f", string(CardFascist):
+			cards[i] = CardFascist
+		default:
+			return nil, false
+		}
+	}
+	return cards, true
+}
+
+// claimRejection gets the reason why the given player can't claim the hand they got in the latest government
+func (game *Game) claimRejection(player *Player) string {
+	gov := game.CurrentGovernment()
+	if gov == nil || !gov.Finished() {
+		return "wrongphase"
+	} else if player.Name == gov.President {
+		return requireUnclaimed(gov.PresidentClaim == nil)
+	} else if player.Name == gov.Chancellor {
+		return requireUnclaimed(gov.ChancellorClaim == nil)
+	}
+	return "notyourturn"
+}
+
+func requireUnclaimed(unclaimed bool) string {
+	if !unclaimed {
+		return "alreadyclaimed"
+	}
+	return ""
+}
+
+// Claim is called when the president or chancellor of the latest government claims which cards they got.
+// Claims are only compared with each other and the enacted policy, never with the real hands.
+func (game *Game) Claim(player *Player, cards []Card) string {
+	gov := game.CurrentGovernment()
+	if player.Name == gov.President && len(cards) == 3 {
+		gov.PresidentClaim = cards
+	} else if player.Name == gov.Chancellor && len(cards) == 2 {
+		gov.ChancellorClaim = cards
+	} else {
+		return "invalidcards"
+	}
+	gov.Contradiction = claimsContradict(gov)
+	game.debugln(player.Name, "claimed", cards, "in government", gov.Index)
+	game.Broadcast(ClaimMessage{
+		Type:            TypeClaim,
+		Government:      gov.Index,
+		Name:            player.Name,
+		President:       gov.President,
+		Chancellor:      gov.Chancellor,
+		Cards:           cards,
+		Contradiction:   gov.Contradiction,
+		PresidentClaim:  gov.PresidentClaim,
+		ChancellorClaim: gov.ChancellorClaim,
+	})
+	return ""
+}
+
+func claimsContradict(gov *Government) bool {
+	if len(gov.Enacted) > 0 {
+		if gov.PresidentClaim != nil && countCards(gov.PresidentClaim, gov.Enacted) == 0 {
+			return true
+		} else if gov.ChancellorClaim != nil && countCards(gov.ChancellorClaim, gov.Enacted) == 0 {
+			return true
+		}
+	}
+	if gov.PresidentClaim == nil || gov.ChancellorClaim == nil {
+		return false
+	}
+	// The chancellor's hand must be the president's hand with one card removed
+	for _, card := range []Card{CardLiberal, CardFascist} {
+		if countCards(gov.ChancellorClaim, card) > countCards(gov.PresidentClaim, card) {
+			return true
+		}
+	}
+	return false
+}
+
+func countCards(cards []Card, card Card) (n int) {
+	for _, c := range cards {
+		if c == card {
+			n++
+		}
+	}
+	return
+}
+
+// ExecutiveAction is a single investigation or policy peek performed by a president.
+// The real result is only revealed when the game ends.
+type ExecutiveAction struct {
+	Index         int    `json:"index"`
+	Type          Type   `json:"type"`
+	President     string `json:"president"`
+	Target        string `json:"target,omitempty"`
+	Result        Card   `json:"result,omitempty"`
+	Peeked        []Card `json:"peeked,omitempty"`
+	ClaimedResult Card   `json:"claimedResult,omitempty"`
+	ClaimedPeek   []Card `json:"claimedPeek,omitempty"`
+	Lied          bool   `json:"lied,omitempty"`
+}
+
+// Claimed checks if the president has made a claim about the action
+func (act *ExecutiveAction) Claimed() bool {
+	return len(act.ClaimedResult) > 0 || act.ClaimedPeek != nil
+}
+
+// sameCards checks if the given hands contain the same cards in the same order
+func sameCards(a, b []Card) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyActions compares every claimed action result with the real result
+func (game *Game) verifyActions() {
+	for _, act := range game.Actions {
+		if len(act.ClaimedResult) > 0 {
+			act.Lied = act.ClaimedResult != act.Result
+		} else if act.ClaimedPeek != nil {
+			act.Lied = !sameCards(act.ClaimedPeek, act.Peeked)
+		}
+	}
+}
+
+func (game *Game) addAction(act *ExecutiveAction) {
+	act.Index = len(game.Actions)
+	act.President = game.President.Name
+	game.Actions = append(game.Actions, act)
+}
+
+// PublicActions returns copies of all executive actions with the real results removed unless the game has ended
+func (game *Game) PublicActions() []ExecutiveAction {
+	acts := make([]ExecutiveAction, len(game.Actions))
+	for i, act := range game.Actions {
+		acts[i] = *act
+		if !game.Ended {
+			acts[i].Result = ""
+			acts[i].Peeked = nil
+		}
+	}
+	return acts
+}
+
+// unclaimedAction gets the latest action of the given type performed by the given player, if it hasn't been claimed yet
+func (game *Game) unclaimedAction(player *Player, typ Type) *ExecutiveAction {
+	for i := len(game.Actions) - 1; i >= 0; i-- {
+		act := game.Actions[i]
+		if act.Type == typ && act.President == player.Name {
+			if act.Claimed() {
+				return nil
+			}
+			return act
+		}
+	}
+	return nil
+}
+
+func (game *Game) actionClaimRejection(player *Player, typ Type) string {
+	if game.unclaimedAction(player, typ) != nil {
+		return ""
+	}
+	for _, act := range game.Actions {
+		if act.Type == typ && act.President == player.Name {
+			return "alreadyclaimed"
+		}
+	}
+	return "notyourturn"
+}
+
+// ClaimInvestigation is called when a president claims the party they saw when investigating a player
+func (game *Game) ClaimInvestigation(player *Player, result Card) string {
+	act := game.unclaimedAction(player, TypeInvestigate)
+	if act == nil {
+		return "notyourturn"
+	} else if result != CardLiberal && result != CardFascist {
+		return "invalidresult"
+	}
+	act.ClaimedResult = result
+	game.debugln(player.Name, "claimed", act.Target, "is", result)
+	game.Broadcast(ActionClaim{Type: TypeClaimInvestigation, Action: act.Index, President: player.Name, Name: act.Target, Result: result})
+	return ""
+}
+
+// ClaimPeek is called when a president claims the cards they saw when peeking at the deck
+func (game *Game) ClaimPeek(player *Player, cards []Card) string {
+	act := game.unclaimedAction(player, TypePeekBroadcast)
+	if act == nil {
+		return "notyourturn"
+	} else if len(cards) != 3 {
+		return "invalidcards"
+	}
+	act.ClaimedPeek = cards
+	game.debugln(player.Name, "claimed the top cards were", cards)
+	game.Broadcast(ActionClaim{Type: TypeClaimPeek, Action: act.Index, President: player.Name, Cards: cards})
+	return ""
+}
@@ -19,12 +19,16 @@ package game
 
 import (
 	crand "crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
 	"flag"
 	"fmt"
 	"math/rand"
 	"os"
+	"strings"
 	"time"
+
+	"maunium.net/go/shitlerd/accounts"
 )
 
 var dbg = flag.Bool("debug", false, "Print gameplay debug/log messages")
@@ -33,15 +37,42 @@ var r = rand.New(rand.NewSource(time.Now().UnixNano()))
 // Game contains a single Secret Hitler game
 type Game struct {
 	Name       string
+	Created    time.Time
+	StartTime  time.Time
+	Settings   Settings
 	Players    []*Player
+	Spectators []*Player
+	Streamers  []*Player
 	Cards      *Cards
 	Discarding []Card
 	Started    bool
 	Ended      bool
 
+	Room           *Room
+	Rematch        *Game
+	StartingSeat   int
+	FirstPresident int
+	rematchPending map[string]bool
+
+	StreamToken  string
+	streamQueue  []streamEvent
+	chatCounter  int64
+	eventCounter int64
+	chatLog      []Chat
+
+	Host        *Player
+	HostToken   string
+	Locked      bool
+	BannedNames map[string]bool
+	BannedAddrs map[string]bool
+
+	countdown *time.Timer
+
 	VetoRequested bool
 	State         Action
 	FailedGovs    int
+	Governments   []*Government
+	Actions       []*ExecutiveAction
 
 	PresidentIndex     int
 	PreviousPresident  *Player
@@ -50,21 +81,34 @@ type Game struct {
 	Chancellor         *Player
 }
 
-// CreateGame creates a game with the default cards and max 10 players
-func CreateGame(name string) *Game {
-	return &Game{Name: name, Players: make([]*Player, 10), Cards: CreateDeck()}
+// CreateGame creates a game with the default cards and the given settings
+func CreateGame(name string, settings Settings) *Game {
+	settings.Normalize()
+	game := &Game{Name: name, Created: time.Now(), Settings: settings, Players: make([]*Player, settings.MaxPlayers), Cards: CreateDeck()}
+	game.HostToken = game.createAuthToken()
+	game.StreamToken = game.createAuthToken()
+	game.BannedNames = make(map[string]bool)
+	game.BannedAddrs = make(map[string]bool)
+	game.StartingSeat = -1
+	game.rematchPending = make(map[string]bool)
+	return game
 }
 
 // Join the given player
 func (game *Game) Join(name, authtoken string, conn Connection) (interface{}, *Player) {
-	if game.Started && len(authtoken) == 0 {
+	// Once the game has started, the only way in is reconnecting to an existing seat.
+	if game.Started && !game.GetPlayer(name).ownedBy(authtoken, conn) {
 		return "gamestarted", nil
 	} else if !validName(name) {
 		return "invalidname", nil
+	} else if !filterName(name) {
+		return "nameblocked", nil
+	} else if !ownsName(conn, name) {
+		return "namereserved", nil
 	}
 	for i, player := range game.Players {
 		if player != nil && player.Name == name {
-			if player.AuthToken != authtoken {
+			if !player.ownedBy(authtoken, conn) {
 				return "nameused", nil
 			}
 			player.Game.Broadcast(JoinPart{Type: TypeConnected, Name: player.Name})
@@ -72,6 +116,10 @@ func (game *Game) Join(name, authtoken string, conn Connection) (interface{}, *P
 			oldConn := player.Conn
 			player.Conn = conn
 			player.Connected = true
+			if player.leaveTimer != nil {
+				player.leaveTimer.Stop()
+				player.leaveTimer = nil
+			}
 			if oldConn != nil {
 				oldConn.SendMessage("connected-other")
 				oldConn.Close()
@@ -79,17 +127,182 @@ func (game *Game) Join(name, authtoken string, conn Connection) (interface{}, *P
 			return i, player
 		}
 	}
+	if game.GetSpectator(name) != nil {
+		return "nameused", nil
+	} else if game.Locked {
+		return "locked", nil
+	} else if game.IsBanned(name, conn) {
+		return "banned", nil
+	}
 	for i, player := range game.Players {
 		if player == nil {
 			game.Broadcast(JoinPart{Type: TypeJoin, Name: name})
-			game.Players[i] = &Player{Name: name, AuthToken: game.createAuthToken(), Connected: true, Alive: true, Vote: VoteEmpty, Conn: conn, Game: game}
+			game.Players[i] = &Player{Name: name, AuthToken: game.createAuthToken(), Account: accountFor(conn, name), Connected: true, Alive: true, Vote: VoteEmpty, Conn: conn, Game: game}
 			game.debugln(game.Players[i].Name, "joined the game")
+			if game.Room != nil {
+				game.Room.AddMember(name)
+			}
+			if game.Host == nil {
+				game.SetHost(game.Players[i])
+			}
+			game.BroadcastSeats()
+			game.listingChanged()
+			game.CheckAutoStart()
 			return i, game.Players[i]
 		}
 	}
 	return "full", nil
 }
 
+// CheckPassword checks if the given password can be used to join the game
+func (game *Game) CheckPassword(password string) bool {
+	if len(game.Settings.Password) == 0 {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(game.Settings.Password), []byte(password)) == 1
+}
+
+// IsBanned checks if the given name or the address of the given connection has been banned by the host
+func (game *Game) IsBanned(name string, conn Connection) bool {
+	if game.BannedNames[strings.ToLower(name)] {
+		return true
+	} else if conn != nil && len(conn.RemoteAddr()) > 0 && game.BannedAddrs[conn.RemoteAddr()] {
+		return true
+	}
+	return false
+}
+
+// ClaimHost makes the given player the host if the host token is correct
+func (game *Game) ClaimHost(player *Player, hosttoken string) bool {
+	if len(hosttoken) == 0 || subtle.ConstantTimeCompare([]byte(game.HostToken), []byte(hosttoken)) != 1 {
+		return false
+	} else if game.Host != player {
+		game.SetHost(player)
+	}
+	return true
+}
+
+// SetHost sets the host of the game and tells everyone about it
+func (game *Game) SetHost(player *Player) {
+	game.Host = player
+	if player == nil {
+		game.debugln("The game no longer has a host")
+		return
+	}
+	game.debugln(player.Name, "is now the host")
+	if game.Room != nil {
+		game.Room.Host = player.Name
+	}
+	game.Broadcast(JoinPart{Type: TypeHost, Name: player.Name})
+	game.listingChanged()
+}
+
+// PassHost gives the host role to the next connected player after the current host
+func (game *Game) PassHost() {
+	start := 0
+	for i, player := range game.Players {
+		if player != nil && player == game.Host {
+			start = i + 1
+			break
+		}
+	}
+	for i := 0; i < len(game.Players); i++ {
+		player := game.Players[(start+i)%len(game.Players)]
+		if player != nil && player != game.Host && player.Connected && player.Alive {
+			game.SetHost(player)
+			return
+		}
+	}
+	game.SetHost(nil)
+}
+
+// Kick removes the given player from the lobby. If ban is true, the name and address of the player are also banned.
+func (game *Game) Kick(name string, ban bool) {
+	if game.Started {
+		return
+	}
+	for i, player := range game.Players {
+		if player == nil || player.Name != name || player == game.Host {
+			continue
+		}
+		typ := TypeKicked
+		if ban {
+			typ = TypeBanned
+			game.BannedNames[strings.ToLower(player.Name)] = true
+			if player.Conn != nil && len(player.Conn.RemoteAddr()) > 0 {
+				game.BannedAddrs[player.Conn.RemoteAddr()] = true
+			}
+		}
+		game.debugln(game.Host.Name, typ, player.Name)
+		game.Broadcast(Kicked{Type: typ, Host: game.Host.Name, Name: player.Name})
+		game.Players[i] = nil
+		if player.Conn != nil {
+			player.Conn.Close()
+			player.Conn = nil
+		}
+		player.Connected = false
+		game.BroadcastSeats()
+		game.listingChanged()
+		game.CheckAutoStart()
+		return
+	}
+}
+
+// SetLocked locks or unlocks the lobby. New players can't join locked lobbies.
+func (game *Game) SetLocked(locked bool) {
+	if game.Locked == locked {
+		return
+	}
+	game.Locked = locked
+	if game.Room != nil {
+		game.Room.Locked = locked
+	}
+	if locked {
+		game.debugln(game.Host.Name, "locked the lobby")
+		game.Broadcast(JoinPart{Type: TypeLock, Name: game.Host.Name})
+	} else {
+		game.debugln(game.Host.Name, "unlocked the lobby")
+		game.Broadcast(JoinPart{Type: TypeUnlock, Name: game.Host.Name})
+	}
+	game.listingChanged()
+}
+
+// CheckName checks if the given name can be used by players. The returned string is the error code, or empty if the name is allowed.
+func CheckName(name string) string {
+	if !validName(name) {
+		return "invalidname"
+	} else if !filterName(name) {
+		return "nameblocked"
+	}
+	return ""
+}
+
+// ownsName checks if the given name is either not registered or owned by the account logged in on the given connection
+func ownsName(conn Connection, name string) bool {
+	if !accounts.Registered(name) {
+		return true
+	}
+	return conn != nil && conn.Account().Owns(name)
+}
+
+// ownedBy checks if the given auth token or the account logged in on the given connection owns this seat
+func (player *Player) ownedBy(authtoken string, conn Connection) bool {
+	if player == nil {
+		return false
+	} else if len(authtoken) > 0 && subtle.ConstantTimeCompare([]byte(player.AuthToken), []byte(authtoken)) == 1 {
+		return true
+	}
+	return player.Account != nil && conn != nil && player.Account == conn.Account()
+}
+
+// accountFor gets the account logged in on the given connection if the account owns the given name
+func accountFor(conn Connection, name string) *accounts.Account {
+	if conn == nil || !conn.Account().Owns(name) {
+		return nil
+	}
+	return conn.Account()
+}
+
 func validName(name string) bool {
 	return validNameLength(name) && validNameChars(name)
 }
@@ -117,11 +330,21 @@ func (game *Game) Leave(name string) {
 		if player != nil && player.Name == name {
 			if !game.Started {
 				game.Players[i] = nil
+				if player.Connected {
+					player.detach()
+				}
+				game.BroadcastSeats()
 			} else {
 				game.Players[i].Alive = false
+				game.BroadcastSeats()
 			}
 			game.Broadcast(JoinPart{Type: TypePart, Name: name})
 			game.debugln(player.Name, "left the game")
+			if player == game.Host {
+				game.PassHost()
+			}
+			game.listingChanged()
+			game.CheckAutoStart()
 		}
 	}
 }
@@ -145,6 +368,26 @@ func (game *Game) createAuthToken() string {
 	return base64.StdEncoding.EncodeToString(cs)
 }
 
+func createInviteSecret() string {
+	cs := make([]byte, 9)
+	_, err := crand.Read(cs)
+	if err != nil {
+		rand.Read(cs)
+	}
+	return base64.RawURLEncoding.EncodeToString(cs)
+}
+
+// PlayerStates maps the names of players in this game to whether or not they're connected
+func (game *Game) PlayerStates() map[string]bool {
+	players := make(map[string]bool)
+	for _, p := range game.Players {
+		if p != nil {
+			players[p.Name] = p.Connected
+		}
+	}
+	return players
+}
+
 // PlayerCount gets the count of players in the game.
 func (game *Game) PlayerCount() (i int) {
 	for _, player := range game.Players {
@@ -203,13 +446,18 @@ func (game *Game) Fascists() int {
 	return game.PlayerCount() - liberals - 1
 }
 
-// Broadcast a message to all players
+// Broadcast a message to all players and spectators
 func (game *Game) Broadcast(msg interface{}) {
+	game.eventCounter++
+	evt := Event{ID: game.eventCounter, Time: time.Now().Unix()}
 	for _, player := range game.Players {
 		if player != nil {
-			player.SendMessage(msg)
+			player.sendEvent(msg, evt)
 		}
 	}
+	game.broadcastSpectators(msg, evt)
+	evt.Message = msg
+	game.Stream(evt)
 }
 
 // BroadcastTable broadcasts the current status of the table to everyone
@@ -230,77 +478,208 @@ func (game *Game) GetTable() Table {
 
 // Player is a single player in a single Secret Hitler game
 type Player struct {
-	Role      Role
-	Name      string
-	AuthToken string
-	Connected bool
-	Alive     bool
-	Vote      Vote
-	Conn      Connection
-	Game      *Game
+	Role       Role
+	Name       string
+	AuthToken  string
+	Account    *accounts.Account
+	Connected  bool
+	Alive      bool
+	Ready      bool
+	Spectator  bool
+	Omniscient bool
+	Muted      bool
+	Vote       Vote
+	Conn       Connection
+	Game       *Game
+
+	leaveTimer  *time.Timer
+	chatTimes   []time.Time
+	request     Field
+	rejected    bool
+	streamReady bool
+}
+
+// detach detaches the connection from the player after the player has left, so that the client can join another game
+func (player *Player) detach() {
+	if player.Conn != nil {
+		player.Conn.SetPlayer(nil)
+		player.Conn = nil
+	}
+	player.Connected = false
 }
 
 // Disconnect is called when a player disconnects
 func (player *Player) Disconnect() {
+	if player.Spectator {
+		player.Conn = nil
+		player.Game.RemoveSpectator(player)
+		return
+	}
 	player.Connected = false
 	player.Conn = nil
 	player.Game.Broadcast(JoinPart{Type: TypeDisconnected, Name: player.Name})
 	player.Game.debugln(player.Name, "disconnected")
+	player.Game.WarnDisconnected(player)
+	player.Game.CheckAutoStart()
 }
 
-// SendMessage sends a message to the client
+// SendMessage sends a message to the client. Messages sent to seated players are also added to the omniscient stream.
 func (player *Player) SendMessage(msg interface{}) {
-	if player.Conn != nil {
-		player.Conn.SendMessage(msg)
+	if !player.Spectator {
+		player.Game.StreamPrivate(player, msg)
 	}
+	player.send(msg)
+}
+
+// send sends a message to the client if the visibility rules allow it
+func (player *Player) send(msg interface{}) {
+	player.sendEvent(msg, Event{})
+}
+
+// sendEvent sends a message to the client if the visibility rules allow it.
+// If the event has an ID, the ID and time are added to the message.
+func (player *Player) sendEvent(msg interface{}, evt Event) {
+	if player.Conn == nil {
+		return
+	}
+	msg, ok := player.Game.Visible(player, msg)
+	if !ok {
+		player.Game.debugfln("Blocked a %T message to %s", msg, player.Name)
+		return
+	}
+	if evt.ID > 0 {
+		evt.Message = msg
+		msg = evt
+	}
+	player.Conn.SendMessage(msg)
+}
+
+// ReceiveMessage should be called by the connection when the client sends a message.
+// It returns false if the message was rejected.
+func (player *Player) ReceiveMessage(msg Message) bool {
+	player.request = msg.ID
+	player.rejected = false
+	player.receiveMessage(msg)
+	player.request = ""
+	return !player.rejected
 }
 
-// ReceiveMessage should be called by the connection when the client sends a message
-func (player *Player) ReceiveMessage(msg map[string]interface{}) {
+func (player *Player) receiveMessage(msg Message) {
 	game := player.Game
-	if msg["type"] == TypeChat.String() && player.Alive {
-		game.Broadcast(Chat{Type: TypeChat, Sender: player.Name, Message: msg["message"].(string)})
-	} else if msg["type"] == TypeStart.String() && !game.Started && game.ConnectedPlayers() >= 5 {
+	if player.Spectator {
+		player.ReceiveSpectatorMessage(msg)
+	} else if msg.Type == TypeChat {
+		player.Chat(msg.Message.String())
+	} else if msg.Type == TypeStart && player == game.Host && !game.Started && game.ConnectedPlayers() >= game.Settings.MinPlayers {
 		game.debugln(player.Name, "requested the game to start")
 		game.Start()
-	} else if msg["type"] == TypePart.String() {
+	} else if msg.Type == TypePart {
 		game.Leave(player.Name)
+	} else if msg.Type == TypeReport {
+		player.ReceiveReport(msg)
+	} else if (msg.Type == TypeMute || msg.Type == TypeUnmute) && player == game.Host {
+		game.Mute(msg.Name.String(), msg.Type == TypeMute)
+	} else if msg.Type == TypeReady && !game.Started {
+		game.SetReady(player, msg.Ready != "false")
+	} else if msg.Type == TypeCancelCountdown && !game.Started {
+		game.CancelCountdown(player)
+	} else if msg.Type == TypeRematch && game.Ended {
+		game.RequestRematch(player)
+	} else if player == game.Host && !game.Started {
+		player.ReceiveHostMessage(msg)
+	} else if msg.Type.HostOnly() && player != game.Host {
+		player.Reject(msg, "nothost")
 	} else if !game.Started || game.Ended || !player.Alive {
-		game.debugln(player.Name, "tried to send a", msg["type"], "message!")
+		game.debugln(player.Name, "tried to send a", msg.Type, "message!")
 		game.debugln("  Game started/ended:", game.Started, game.Ended)
 		game.debugln("  Player alive:", player.Alive)
 		game.debugln("  Players joined/alive/connected", game.PlayerCount(), game.PlayersInGame(), game.ConnectedPlayers())
-		return
+		if player.Alive || !game.Started || game.Ended {
+			player.Reject(msg, "wrongphase")
+		} else {
+			player.Reject(msg, "notalive")
+		}
 	} else {
 		player.ReceiveGameMessage(msg)
 	}
 }
 
+// ReceiveReport is called from ReceiveMessage when the client reports a chat message
+func (player *Player) ReceiveReport(msg Message) {
+	id, _ := msg.MessageID.Int()
+	player.Game.Report(player, int64(id), msg.Reason.String())
+}
+
+// ReceiveHostMessage is called from ReceiveMessage when the host sends a message before the game has started.
+func (player *Player) ReceiveHostMessage(msg Message) {
+	game := player.Game
+	name := msg.Name.String()
+	if msg.Type == TypeKick {
+		game.Kick(name, false)
+	} else if msg.Type == TypeBan {
+		game.Kick(name, true)
+	} else if msg.Type == TypeLock {
+		game.SetLocked(true)
+	} else if msg.Type == TypeUnlock {
+		game.SetLocked(false)
+	} else if msg.Type == TypeShuffleSeats {
+		game.ShuffleSeats()
+	} else if msg.Type == TypeMoveSeat {
+		index, _ := msg.Index.Int()
+		game.MoveSeat(name, index)
+	} else if msg.Type == TypeStart {
+		player.Reject(msg, "notenoughplayers")
+	} else {
+		player.Reject(msg, "wrongphase")
+	}
+}
+
 // ReceiveGameMessage is called from ReceiveMessage when the received message is directly related to the ongoing game.
-func (player *Player) ReceiveGameMessage(msg map[string]interface{}) {
+func (player *Player) ReceiveGameMessage(msg Message) {
 	game := player.Game
-	if msg["type"] == TypeVote.String() && TypeVote.ReceiveRequirements(player) {
-		game.Vote(player, msg["vote"].(string))
-	} else if msg["type"] == TypePickChancellor.String() && TypePickChancellor.ReceiveRequirements(player) {
-		game.PickChancellor(msg["name"].(string))
-	} else if msg["type"] == TypeDiscard.String() && TypeDiscard.ReceiveRequirements(player) {
-		game.DiscardCard(int(msg["index"].(float64)))
-	} else if msg["type"] == TypeVetoRequest.String() && TypeVetoRequest.ReceiveRequirements(player) {
+	if code := msg.Type.Rejection(player); len(code) > 0 {
+		player.Reject(msg, code)
+		return
+	}
+	var code string
+	switch msg.Type {
+	case TypeVote:
+		game.Vote(player, msg.Vote.String())
+	case TypePickChancellor:
+		code = game.PickChancellor(msg.Name.String())
+	case TypeDiscard:
+		index, _ := msg.Index.Int()
+		code = game.DiscardCard(index)
+	case TypeVetoRequest:
 		game.VetoRequest()
-	} else if msg["type"] == TypeVetoAccept.String() && TypeVetoAccept.ReceiveRequirements(player) {
+	case TypeVetoAccept:
 		game.VetoAccept()
-	} else if msg["type"] == TypePresidentSelect.String() && TypePresidentSelect.ReceiveRequirements(player) {
-		game.SelectedPresident(msg["name"].(string))
-	} else if msg["type"] == TypeExecute.String() && TypeExecute.ReceiveRequirements(player) {
-		game.ExecutedPlayer(msg["name"].(string))
-	} else if msg["type"] == TypeInvestigate.String() && TypeInvestigate.ReceiveRequirements(player) {
-		game.Investigated(msg["name"].(string))
+	case TypePresidentSelect:
+		code = game.SelectedPresident(msg.Name.String())
+	case TypeExecute:
+		code = game.ExecutedPlayer(msg.Name.String())
+	case TypeInvestigate:
+		code = game.Investigated(msg.Name.String())
+	case TypeClaim:
+		cards, _ := ParseCards(msg.Cards.String())
+		code = game.Claim(player, cards)
+	case TypeClaimInvestigation:
+		code = game.ClaimInvestigation(player, Card(msg.Result))
+	case TypeClaimPeek:
+		cards, _ := ParseCards(msg.Cards.String())
+		code = game.ClaimPeek(player, cards)
+	}
+	if len(code) > 0 {
+		player.Reject(msg, code)
 	}
 }
 
 // Connection is a way to send messages to a player
 type Connection interface {
 	SendMessage(msg interface{})
+	RemoteAddr() string
+	SetPlayer(player *Player)
+	Account() *accounts.Account
 	Close()
 }
 
@@ -0,0 +1,177 @@
+// shitlerd - A manager for online Secret Hitler games
+// Copyright (C) 2016-2017 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package game contains the game management code
+package game
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var errInvalidField = errors.New("field must be a string, a number, a boolean or an array of strings")
+
+// Field is a field of a client message. Fields should be sent as strings, but numbers and booleans are also
+// accepted and converted to strings. Arrays of strings are joined with commas.
+type Field string
+
+// UnmarshalJSON converts the JSON value into a Field
+func (field *Field) UnmarshalJSON(data []byte) error {
+	var val interface{}
+	err := json.Unmarshal(data, &val)
+	if err != nil {
+		return err
+	}
+	switch v := val.(type) {
+	case nil:
+		*field = ""
+	case string:
+		*field = Field(v)
+	case float64:
+		*field = Field(strconv.FormatFloat(v, 'f', -1, 64))
+	case bool:
+		*field = Field(strconv.FormatBool(v))
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			str, ok := item.(string)
+			if !ok {
+				return errInvalidField
+			}
+			parts[i] = str
+		}
+		*field = Field(strings.Join(parts, ","))
+	default:
+		return errInvalidField
+	}
+	return nil
+}
+
+// String returns the field as a string
+func (field Field) String() string {
+	return string(field)
+}
+
+// Int parses the field as an integer
+func (field Field) Int() (int, bool) {
+	i, err := strconv.Atoi(string(field))
+	return i, err == nil
+}
+
+// Message is a message received from a client. Every client message is decoded into this struct
+// and the fields that the message type doesn't use are ignored.
+type Message struct {
+	Type Type  `json:"type"`
+	ID   Field `json:"id"`
+
+	Version      Field `json:"version"`
+	Capabilities Field `json:"capabilities"`
+
+	Game            Field `json:"game"`
+	Room            Field `json:"room"`
+	Name            Field `json:"name"`
+	Password        Field `json:"password"`
+	AuthToken       Field `json:"authtoken"`
+	HostToken       Field `json:"hosttoken"`
+	StreamToken     Field `json:"streamtoken"`
+	Spectate        Field `json:"spectate"`
+	AccountToken    Field `json:"accounttoken"`
+	AccountPassword Field `json:"accountpassword"`
+	Variant         Field `json:"variant"`
+	Size            Field `json:"size"`
+
+	Message   Field `json:"message"`
+	MessageID Field `json:"messageID"`
+	Reason    Field `json:"reason"`
+	Ready     Field `json:"ready"`
+	Vote      Field `json:"vote"`
+	Index     Field `json:"index"`
+	Cards     Field `json:"cards"`
+	Result    Field `json:"result"`
+}
+
+// ParseMessage decodes and validates a client message. If the message is invalid, the returned string is the error code.
+func ParseMessage(data []byte) (msg Message, code string) {
+	err := json.Unmarshal(data, &msg)
+	if err != nil {
+		return msg, "invalidmessage"
+	}
+	return msg, msg.Validate()
+}
+
+// Validate checks that the message has a known type and that the fields the type requires are valid.
+// The returned string is the error code, or empty if the message is valid.
+func (msg Message) Validate() string {
+	if _, ok := msg.Version.Int(); len(msg.Version) > 0 && !ok {
+		return "invalidversion"
+	}
+	switch msg.Type {
+	case "":
+		return "missingtype"
+	case TypeJoin, TypeQueue:
+		if len(msg.Name) == 0 {
+			return "missingname"
+		}
+	case TypeVote:
+		if ParseVote(msg.Vote.String()) == VoteEmpty {
+			return "invalidvote"
+		}
+	case TypePickChancellor, TypePresidentSelect, TypeExecute, TypeInvestigate, TypeKick, TypeBan, TypeMute, TypeUnmute:
+		if len(msg.Name) == 0 {
+			return "missingname"
+		}
+	case TypeMoveSeat:
+		if len(msg.Name) == 0 {
+			return "missingname"
+		} else if _, ok := msg.Index.Int(); !ok {
+			return "invalidindex"
+		}
+	case TypeDiscard:
+		if _, ok := msg.Index.Int(); !ok {
+			return "invalidindex"
+		}
+	case TypeClaim, TypeClaimPeek:
+		if _, ok := ParseCards(msg.Cards.String()); !ok {
+			return "invalidcards"
+		}
+	case TypeClaimInvestigation:
+		if Card(msg.Result) != CardLiberal && Card(msg.Result) != CardFascist {
+			return "invalidresult"
+		}
+	case TypeReport:
+		if _, ok := msg.MessageID.Int(); !ok {
+			return "messagenotfound"
+		}
+	case TypeChat, TypeStart, TypePart, TypeReady, TypeCancelCountdown, TypeRematch, TypeLock, TypeUnlock,
+		TypeShuffleSeats, TypeVetoRequest, TypeVetoAccept, TypeGames, TypeUnsubscribe, TypeLeaveQueue:
+	default:
+		return "unknowntype"
+	}
+	return ""
+}
+
+// Reject tells the player that the given message was refused
+func (player *Player) Reject(msg Message, code string) {
+	player.reject(msg.Type, code)
+}
+
+// reject tells the player that the message they sent was refused. The ID of the message being handled is included.
+func (player *Player) reject(request Type, code string) {
+	player.rejected = true
+	player.SendMessage(Rejected{Type: TypeRejected, ID: player.request, Code: code, Request: request})
+}
@@ -0,0 +1,107 @@
+// shitlerd - A manager for online Secret Hitler games
+// Copyright (C) 2016-2017 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package game contains the game management code
+package game
+
+import (
+	"time"
+)
+
+// SetReady marks the given player as ready or not ready to start the game
+func (game *Game) SetReady(player *Player, ready bool) {
+	if game.Started || player.Ready == ready {
+		return
+	}
+	player.Ready = ready
+	game.debugln(player.Name, "ready:", ready)
+	game.Broadcast(Ready{Type: TypeReady, Name: player.Name, Ready: ready})
+	game.CheckAutoStart()
+}
+
+// AllReady checks if every seated player is connected and ready
+func (game *Game) AllReady() bool {
+	for _, player := range game.Players {
+		if player != nil && (!player.Connected || !player.Ready) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckAutoStart starts the countdown if auto-start is enabled, enough players have joined and everyone is ready.
+// If a countdown is running and the conditions are no longer met, the countdown is cancelled.
+func (game *Game) CheckAutoStart() {
+	if game.Started || !game.Settings.AutoStart {
+		return
+	}
+	canStart := game.ConnectedPlayers() >= game.Settings.MinPlayers && game.AllReady()
+	if game.countdown != nil && !canStart {
+		game.CancelCountdown(nil)
+	} else if game.countdown == nil && canStart {
+		seconds := game.Settings.Timers.Countdown
+		game.debugln("Starting in", seconds, "seconds")
+		game.Broadcast(Countdown{Type: TypeCountdown, Seconds: seconds})
+		var countdown *time.Timer
+		countdown = afterFunc(time.Duration(seconds)*time.Second, func() {
+			if game.countdown != countdown {
+				return
+			}
+			game.countdown = nil
+			if game.ConnectedPlayers() >= game.Settings.MinPlayers && game.AllReady() {
+				game.Start()
+			}
+		})
+		game.countdown = countdown
+	}
+}
+
+// CancelCountdown stops the auto-start countdown. If a player cancelled the countdown, they're also marked as not ready.
+func (game *Game) CancelCountdown(player *Player) {
+	if game.countdown == nil {
+		return
+	}
+	game.countdown.Stop()
+	game.countdown = nil
+	name := ""
+	if player != nil {
+		name = player.Name
+	}
+	game.debugln("Countdown cancelled", name)
+	game.Broadcast(JoinPart{Type: TypeCancelCountdown, Name: name})
+	if player != nil {
+		game.SetReady(player, false)
+	}
+}
+
+// WarnDisconnected is called when a player disconnects from the lobby.
+// Everyone is warned and the player is removed unless they reconnect within the disconnect timer.
+func (game *Game) WarnDisconnected(player *Player) {
+	if game.Started {
+		return
+	}
+	seconds := game.Settings.Timers.Disconnect
+	game.Broadcast(DisconnectWarning{Type: TypeDisconnectWarning, Name: player.Name, Seconds: seconds})
+	if player.leaveTimer != nil {
+		player.leaveTimer.Stop()
+	}
+	player.leaveTimer = afterFunc(time.Duration(seconds)*time.Second, func() {
+		if !game.Started && !player.Connected && game.GetPlayer(player.Name) == player {
+			game.debugln(player.Name, "didn't reconnect in time")
+			game.Leave(player.Name)
+		}
+	})
+}
@@ -0,0 +1,47 @@
+// shitlerd - A manager for online Secret Hitler games
+// Copyright (C) 2016-2017 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package game contains the game management code
+package game
+
+import (
+	"sync"
+	"time"
+)
+
+// lock guards all games, rooms and the matchmaking queue. Client messages, disconnections,
+// HTTP requests and timer callbacks all hold it while they touch the game state.
+var lock sync.Mutex
+
+// Lock locks the game state. It must be held when calling into this package from another goroutine.
+func Lock() {
+	lock.Lock()
+}
+
+// Unlock unlocks the game state
+func Unlock() {
+	lock.Unlock()
+}
+
+// afterFunc calls the given function after the given duration while holding the game lock.
+// The timer may fire while it's being stopped, so the function must check that it's still relevant.
+func afterFunc(d time.Duration, f func()) *time.Timer {
+	return time.AfterFunc(d, func() {
+		lock.Lock()
+		defer lock.Unlock()
+		f()
+	})
+}
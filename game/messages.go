@@ -17,6 +17,11 @@
 // Package game contains the game management code
 package game
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 // Type is the type of a message
 type Type string
 
@@ -26,69 +31,162 @@ func (typ Type) String() string {
 
 // ReceiveRequirements checks if the given player is in a state where he/she is allowed to send a command of this type
 func (typ Type) ReceiveRequirements(player *Player) bool {
+	return len(typ.Rejection(player)) == 0
+}
+
+// Rejection gets the reason why the given player isn't allowed to send a command of this type right now.
+// An empty string means the command is allowed.
+func (typ Type) Rejection(player *Player) string {
 	game := player.Game
 	switch typ {
 	case TypeVote:
-		return game.State == ActVote
+		return requireTurn(game.State == ActVote, true)
 	case TypePickChancellor:
-		return game.President == player && game.State == ActPickChancellor
+		return requireTurn(game.State == ActPickChancellor, game.President == player)
 	case TypeDiscard:
-		return (game.President == player && game.State == ActDiscardPresident) || (game.Chancellor == player && game.State == ActDiscardChancellor)
+		return requireTurn(game.State == ActDiscardPresident || game.State == ActDiscardChancellor,
+			(game.President == player && game.State == ActDiscardPresident) || (game.Chancellor == player && game.State == ActDiscardChancellor))
 	case TypeVetoRequest:
-		return game.Chancellor == player && game.State == ActDiscardChancellor && game.Cards.TableFascist >= 5
+		if code := requireTurn(game.State == ActDiscardChancellor, game.Chancellor == player); len(code) > 0 {
+			return code
+		} else if game.Cards.TableFascist < 5 {
+			return "vetounavailable"
+		}
+		return ""
 	case TypeVetoAccept:
-		return game.President == player && game.VetoRequested
+		return requireTurn(game.VetoRequested, game.President == player)
 	case TypePresidentSelect:
-		return game.President == player && game.State == ActSelectPresident
+		return requireTurn(game.State == ActSelectPresident, game.President == player)
 	case TypeExecute:
-		return game.President == player && game.State == ActExecution
+		return requireTurn(game.State == ActExecution, game.President == player)
 	case TypeInvestigate:
-		return game.President == player && game.State == ActInvestigatePlayer
+		return requireTurn(game.State == ActInvestigatePlayer, game.President == player)
+	case TypeClaim:
+		return game.claimRejection(player)
+	case TypeClaimInvestigation:
+		return game.actionClaimRejection(player, TypeInvestigate)
+	case TypeClaimPeek:
+		return game.actionClaimRejection(player, TypePeekBroadcast)
+	default:
+		return "wrongphase"
+	}
+}
+
+// HostOnly checks if only the host can send commands of this type
+func (typ Type) HostOnly() bool {
+	switch typ {
+	case TypeStart, TypeKick, TypeBan, TypeLock, TypeUnlock, TypeShuffleSeats, TypeMoveSeat, TypeMute, TypeUnmute:
+		return true
 	default:
 		return false
 	}
 }
 
+// requireTurn returns wrongphase if the game is in the wrong phase and notyourturn if it's someone else's turn
+func requireTurn(phase, turn bool) string {
+	if !phase {
+		return "wrongphase"
+	} else if !turn {
+		return "notyourturn"
+	}
+	return ""
+}
+
 // The possible message types
 const (
-	TypeChat              Type = "chat"
-	TypeJoin              Type = "join"
-	TypePart              Type = "part"
-	TypeConnected         Type = "connected"
-	TypeDisconnected      Type = "disconnected"
-	TypeStart             Type = "start"
-	TypeEnd               Type = "end"
-	TypePresident         Type = "president"
-	TypePickChancellor    Type = "pickchancellor"
-	TypeStartVote         Type = "startvote"
-	TypeVote              Type = "vote"
-	TypePresidentDiscard  Type = "presidentdiscard"
-	TypeChancellorDiscard Type = "chancellordiscard"
-	TypeDiscard           Type = "discard"
-	TypeCards             Type = "cards"
-	TypeTable             Type = "table"
-	TypeError             Type = "error"
-	TypeEnact             Type = "enact"
-	TypeVetoRequest       Type = "vetorequest"
-	TypeVetoAccept        Type = "vetoaccept"
-	TypeEnactForce        Type = "enactforce"
-	TypePeek              Type = "peekcards"
-	TypePeekBroadcast     Type = "peek"
-	TypeInvestigate       Type = "investigate"
-	TypeInvestigated      Type = "investigated"
-	TypeInvestigateResult Type = "investigateresult"
-	TypeExecute           Type = "execute"
-	TypePresidentSelect   Type = "presidentselect"
-	TypePresidentSelected Type = "presidentselected"
-	TypeExecuted          Type = "executed"
-	TypeGovernmentFailed  Type = "governmentfailed"
+	TypeChat               Type = "chat"
+	TypeJoin               Type = "join"
+	TypePart               Type = "part"
+	TypeConnected          Type = "connected"
+	TypeDisconnected       Type = "disconnected"
+	TypeStart              Type = "start"
+	TypeEnd                Type = "end"
+	TypePresident          Type = "president"
+	TypePickChancellor     Type = "pickchancellor"
+	TypeStartVote          Type = "startvote"
+	TypeVote               Type = "vote"
+	TypePresidentDiscard   Type = "presidentdiscard"
+	TypeChancellorDiscard  Type = "chancellordiscard"
+	TypeDiscard            Type = "discard"
+	TypeCards              Type = "cards"
+	TypeTable              Type = "table"
+	TypeError              Type = "error"
+	TypeEnact              Type = "enact"
+	TypeVetoRequest        Type = "vetorequest"
+	TypeVetoAccept         Type = "vetoaccept"
+	TypeEnactForce         Type = "enactforce"
+	TypePeek               Type = "peekcards"
+	TypePeekBroadcast      Type = "peek"
+	TypeInvestigate        Type = "investigate"
+	TypeInvestigated       Type = "investigated"
+	TypeInvestigateResult  Type = "investigateresult"
+	TypeExecute            Type = "execute"
+	TypePresidentSelect    Type = "presidentselect"
+	TypePresidentSelected  Type = "presidentselected"
+	TypeExecuted           Type = "executed"
+	TypeGovernmentFailed   Type = "governmentfailed"
+	TypeHost               Type = "host"
+	TypeKick               Type = "kick"
+	TypeKicked             Type = "kicked"
+	TypeBan                Type = "ban"
+	TypeBanned             Type = "banned"
+	TypeLock               Type = "lock"
+	TypeUnlock             Type = "unlock"
+	TypeGames              Type = "games"
+	TypeUnsubscribe        Type = "unsubscribe"
+	TypeRematch            Type = "rematch"
+	TypeRematched          Type = "rematched"
+	TypeScoreboard         Type = "scoreboard"
+	TypeSeats              Type = "seats"
+	TypeShuffleSeats       Type = "shuffleseats"
+	TypeMoveSeat           Type = "moveseat"
+	TypeReady              Type = "ready"
+	TypeCountdown          Type = "countdown"
+	TypeCancelCountdown    Type = "cancelcountdown"
+	TypeDisconnectWarning  Type = "disconnectwarning"
+	TypeSpectators         Type = "spectators"
+	TypeStream             Type = "stream"
+	TypeStreamStart        Type = "streamstart"
+	TypeDiscarded          Type = "discarded"
+	TypeRejected           Type = "rejected"
+	TypeMute               Type = "mute"
+	TypeMuted              Type = "muted"
+	TypeUnmute             Type = "unmute"
+	TypeUnmuted            Type = "unmuted"
+	TypeReport             Type = "report"
+	TypeReported           Type = "reported"
+	TypeClaim              Type = "claim"
+	TypeClaimInvestigation Type = "claiminvestigation"
+	TypeClaimPeek          Type = "claimpeek"
+	TypeQueue              Type = "queue"
+	TypeLeaveQueue         Type = "leavequeue"
+	TypeMatched            Type = "matched"
+	TypeAck                Type = "ack"
 )
 
 // Chat contains the necessary fields for a chat message
 type Chat struct {
+	Type    Type    `json:"type"`
+	ID      int64   `json:"messageID"`
+	Time    int64   `json:"time"`
+	Channel Channel `json:"channel"`
+	Sender  string  `json:"sender"`
+	Message string  `json:"message"`
+}
+
+// Rejected is sent to the client when the server refuses to handle a message sent by the client
+type Rejected struct {
 	Type    Type   `json:"type"`
-	Sender  string `json:"sender"`
-	Message string `json:"message"`
+	ID      Field  `json:"id,omitempty"`
+	Code    string `json:"code"`
+	Request Type   `json:"request"`
+}
+
+// Ack is sent to the client when a message that contained an ID was handled successfully
+type Ack struct {
+	Type    Type  `json:"type"`
+	ID      Field `json:"id"`
+	Request Type  `json:"request"`
 }
 
 // JoinPart contains the necessary fields for join and part messages
@@ -97,18 +195,35 @@ type JoinPart struct {
 	Name string `json:"name"`
 }
 
+// Kicked is broadcasted when the host kicks, bans, mutes or unmutes a player
+type Kicked struct {
+	Type Type   `json:"type"`
+	Host string `json:"host"`
+	Name string `json:"name"`
+}
+
 // Start contains the necessary fields for a game start message
 type Start struct {
 	Type    Type            `json:"type"`
-	Role    Role            `json:"role"`
+	Role    Role            `json:"role,omitempty"`
 	Players map[string]Role `json:"players"`
+	Seats   []Seat          `json:"seats"`
+}
+
+// Seats is broadcasted when the seating order changes
+type Seats struct {
+	Type  Type   `json:"type"`
+	Seats []Seat `json:"seats"`
 }
 
 // End contains the necessary fields for a game end message
 type End struct {
-	Type   Type            `json:"type"`
-	Winner Card            `json:"winner"`
-	Roles  map[string]Role `json:"roles"`
+	Type        Type               `json:"type"`
+	Winner      Card               `json:"winner"`
+	Reason      EndReason          `json:"reason"`
+	Roles       map[string]Role    `json:"roles"`
+	Governments []*Government      `json:"governments"`
+	Actions     []*ExecutiveAction `json:"actions"`
 }
 
 // Error is sent to the client when something unexpected happens and the game ends
@@ -133,8 +248,9 @@ type StartVote struct {
 
 // VoteMessage contains the necessary field for a vote
 type VoteMessage struct {
-	Type Type `json:"type"`
-	Vote Vote `json:"vote"`
+	Type Type  `json:"type"`
+	ID   Field `json:"id,omitempty"`
+	Vote Vote  `json:"vote"`
 }
 
 // Discard is sent when the someone needs to discard one card
@@ -205,3 +321,177 @@ type GovernmentFailed struct {
 	Times int  `json:"times"`
 	Veto  bool `json:"veto"`
 }
+
+// GameInfo contains the public info about a single game in the game list
+type GameInfo struct {
+	Name       string  `json:"name"`
+	Players    int     `json:"players"`
+	MinPlayers int     `json:"minPlayers"`
+	MaxPlayers int     `json:"maxPlayers"`
+	Host       string  `json:"host"`
+	Variant    Variant `json:"variant"`
+	Started    bool    `json:"started"`
+	Locked     bool    `json:"locked"`
+	Spectators int     `json:"spectators"`
+	Waiting    int     `json:"waiting"`
+	Room       bool    `json:"room,omitempty"`
+}
+
+// Games is sent to clients subscribed to the game list whenever the list changes
+type Games struct {
+	Type  Type       `json:"type"`
+	Games []GameInfo `json:"games"`
+}
+
+// RematchMessage is broadcasted to the players of an ended game when someone requests a rematch
+type RematchMessage struct {
+	Type    Type   `json:"type"`
+	Name    string `json:"name"`
+	Game    string `json:"game"`
+	Timeout int    `json:"timeout"`
+}
+
+// Rematched is sent to a player when they have been moved into a rematch
+type Rematched struct {
+	Type      Type            `json:"type"`
+	Success   bool            `json:"success"`
+	Message   interface{}     `json:"message,omitempty"`
+	Game      string          `json:"game"`
+	Name      string          `json:"name"`
+	AuthToken string          `json:"authtoken,omitempty"`
+	Host      string          `json:"host,omitempty"`
+	Players   map[string]bool `json:"players,omitempty"`
+	Settings  Settings        `json:"settings"`
+}
+
+// Scoreboard is broadcasted to the players of a room when a game in the room ends
+type Scoreboard struct {
+	Type   Type              `json:"type"`
+	Scores map[string]*Score `json:"scores"`
+}
+
+// Ready is broadcasted when a player in the lobby marks themselves as ready or not ready
+type Ready struct {
+	Type  Type   `json:"type"`
+	Name  string `json:"name"`
+	Ready bool   `json:"ready"`
+}
+
+// Countdown is broadcasted when everyone is ready and the game will start automatically
+type Countdown struct {
+	Type    Type `json:"type"`
+	Seconds int  `json:"seconds"`
+}
+
+// DisconnectWarning is broadcasted when a player disconnects from the lobby
+type DisconnectWarning struct {
+	Type    Type   `json:"type"`
+	Name    string `json:"name"`
+	Seconds int    `json:"seconds"`
+}
+
+// SpectatorCount is broadcasted when a spectator starts or stops watching the game
+type SpectatorCount struct {
+	Type  Type `json:"type"`
+	Count int  `json:"count"`
+}
+
+// StreamStart is sent to omniscient spectators when they join. It contains the state of the game at the time they joined,
+// but it's delayed like all other stream events.
+type StreamStart struct {
+	Type        Type               `json:"type"`
+	Delay       int                `json:"delay"`
+	DelayEvents int                `json:"delayEvents"`
+	Started     bool               `json:"started"`
+	Roles       map[string]Role    `json:"roles"`
+	Seats       []Seat             `json:"seats"`
+	Table       *Table             `json:"table,omitempty"`
+	Governments []*Government      `json:"governments,omitempty"`
+	Actions     []*ExecutiveAction `json:"actions,omitempty"`
+}
+
+// StreamEvent wraps a delayed event sent to omniscient spectators
+type StreamEvent struct {
+	Type  Type            `json:"type"`
+	Time  int64           `json:"time"`
+	To    string          `json:"to,omitempty"`
+	Event json.RawMessage `json:"event"`
+}
+
+// Discarded is sent to omniscient spectators when the president or chancellor discards a card
+type Discarded struct {
+	Type Type   `json:"type"`
+	Name string `json:"name"`
+	Card Card   `json:"card"`
+}
+
+// Reported is sent to the client when a chat message they reported has been logged
+type Reported struct {
+	Type Type  `json:"type"`
+	ID   int64 `json:"messageID"`
+}
+
+// ClaimMessage is broadcasted when the president or chancellor claims which cards they got
+type ClaimMessage struct {
+	Type            Type   `json:"type"`
+	Government      int    `json:"government"`
+	Name            string `json:"name"`
+	President       string `json:"president"`
+	Chancellor      string `json:"chancellor"`
+	Cards           []Card `json:"cards"`
+	PresidentClaim  []Card `json:"presidentClaim"`
+	ChancellorClaim []Card `json:"chancellorClaim"`
+	Contradiction   bool   `json:"contradiction"`
+}
+
+// ActionClaim is broadcasted when a president claims the result of an investigation or a policy peek
+type ActionClaim struct {
+	Type      Type   `json:"type"`
+	Action    int    `json:"action"`
+	President string `json:"president"`
+	Name      string `json:"name,omitempty"`
+	Result    Card   `json:"result,omitempty"`
+	Cards     []Card `json:"cards,omitempty"`
+}
+
+// QueueStatus is sent to players in the matchmaking queue whenever the queue changes
+type QueueStatus struct {
+	Type     Type `json:"type"`
+	Position int  `json:"position"`
+	Queued   int  `json:"queued"`
+	ETA      int  `json:"eta"`
+}
+
+// Matched is sent to players in the matchmaking queue when a game is formed for them
+type Matched struct {
+	Type      Type            `json:"type"`
+	Game      string          `json:"game"`
+	Name      string          `json:"name"`
+	Success   bool            `json:"success"`
+	Message   interface{}     `json:"message,omitempty"`
+	AuthToken string          `json:"authtoken,omitempty"`
+	Players   map[string]bool `json:"players,omitempty"`
+	Seats     []Seat          `json:"seats,omitempty"`
+	Settings  Settings        `json:"settings"`
+}
+
+// Event is a broadcasted message with the event ID and the time of the broadcast.
+// The ID and time are added to the fields of the message when serialized.
+type Event struct {
+	ID      int64
+	Time    int64
+	Message interface{}
+}
+
+// MarshalJSON serializes the message and adds the fields eventID and eventTime to it
+func (evt Event) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(evt.Message)
+	if err != nil || len(data) < 2 || data[0] != '{' {
+		return data, err
+	}
+	fields := fmt.Sprintf(`{"eventID":%d,"eventTime":%d`, evt.ID, evt.Time)
+	if data[1] != '}' {
+		fields += ","
+	}
+	return append([]byte(fields), data[1:]...), nil
+}
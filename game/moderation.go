@@ -0,0 +1,209 @@
+// shitlerd - A manager for online Secret Hitler games
+// Copyright (C) 2016-2017 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package game contains the game management code
+package game
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const chatLogSize = 100
+const reportContext = 10
+
+var wordListPath = flag.String("wordList", "", "Path to a file of words (one per line) to censor from chat and names")
+var reportLogPath = flag.String("reportLog", "", "Path to the file chat reports are appended to. Reports are printed to stdout if not set.")
+
+// Filter checks chat messages and player names. Returning false rejects the message or name.
+// The returned string replaces the original, which allows filters to censor parts of messages.
+// Names that a filter changes are rejected, as the censored name hasn't been validated.
+type Filter interface {
+	FilterMessage(sender, message string) (string, bool)
+	FilterName(name string) (string, bool)
+}
+
+// ActiveFilter is the filter used for all chat messages and names. Nil means no filtering.
+var ActiveFilter Filter
+
+// WordFilter is the default filter. It censors the listed words from chat messages and rejects names that contain them.
+type WordFilter struct {
+	pattern *regexp.Regexp
+}
+
+// NewWordFilter creates a WordFilter for the given words
+func NewWordFilter(words []string) *WordFilter {
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		word = strings.TrimSpace(word)
+		if len(word) > 0 {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+	if len(quoted) == 0 {
+		return &WordFilter{}
+	}
+	return &WordFilter{pattern: regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))}
+}
+
+// FilterMessage replaces all listed words in the message with asterisks
+func (filter *WordFilter) FilterMessage(sender, message string) (string, bool) {
+	if filter.pattern == nil {
+		return message, true
+	}
+	return filter.pattern.ReplaceAllStringFunc(message, func(word string) string {
+		return strings.Repeat("*", len([]rune(word)))
+	}), true
+}
+
+// FilterName rejects names that contain any of the listed words
+func (filter *WordFilter) FilterName(name string) (string, bool) {
+	return name, filter.pattern == nil || !filter.pattern.MatchString(name)
+}
+
+// LoadFilter loads the default word filter from the file given with the -wordList flag
+func LoadFilter() error {
+	if len(*wordListPath) == 0 {
+		return nil
+	}
+	file, err := os.Open(*wordListPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		words = append(words, scanner.Text())
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	ActiveFilter = NewWordFilter(words)
+	return nil
+}
+
+func filterMessage(sender, message string) (string, bool) {
+	if ActiveFilter == nil {
+		return message, true
+	}
+	return ActiveFilter.FilterMessage(sender, message)
+}
+
+func filterName(name string) bool {
+	if ActiveFilter == nil {
+		return true
+	}
+	filtered, ok := ActiveFilter.FilterName(name)
+	return ok && filtered == name
+}
+
+// FindMember finds a player or spectator with the given name
+func (game *Game) FindMember(name string) *Player {
+	if player := game.GetPlayer(name); player != nil {
+		return player
+	}
+	for _, spectator := range game.Spectators {
+		if spectator.Name == name {
+			return spectator
+		}
+	}
+	return nil
+}
+
+// Mute mutes or unmutes the chat of the given player for the rest of the game
+func (game *Game) Mute(name string, muted bool) {
+	player := game.FindMember(name)
+	if player == nil || player == game.Host || player.Muted == muted {
+		return
+	}
+	player.Muted = muted
+	typ := TypeMuted
+	if !muted {
+		typ = TypeUnmuted
+	}
+	game.debugln(game.Host.Name, typ, player.Name)
+	game.Broadcast(Kicked{Type: typ, Host: game.Host.Name, Name: player.Name})
+}
+
+func (game *Game) logChat(chat Chat) {
+	game.chatLog = append(game.chatLog, chat)
+	if len(game.chatLog) > chatLogSize {
+		game.chatLog = game.chatLog[len(game.chatLog)-chatLogSize:]
+	}
+}
+
+// Report contains a chat message reported by a player and the messages sent before it
+type Report struct {
+	Time     int64  `json:"time"`
+	Game     string `json:"game"`
+	Reporter string `json:"reporter"`
+	Reason   string `json:"reason"`
+	Message  Chat   `json:"message"`
+	Context  []Chat `json:"context"`
+}
+
+// Report logs the chat message with the given ID for admins. Players can only report messages they were able to see.
+func (game *Game) Report(reporter *Player, messageID int64, reason string) {
+	for i, chat := range game.chatLog {
+		if chat.ID != messageID {
+			continue
+		} else if _, ok := game.Visible(reporter, chat); !ok {
+			break
+		}
+		start := i - reportContext
+		if start < 0 {
+			start = 0
+		}
+		context := []Chat{}
+		for _, prev := range game.chatLog[start:i] {
+			if _, ok := game.Visible(reporter, prev); ok {
+				context = append(context, prev)
+			}
+		}
+		writeReport(Report{Time: time.Now().Unix(), Game: game.Name, Reporter: reporter.Name, Reason: reason, Message: chat, Context: context})
+		game.debugln(reporter.Name, "reported message", messageID, "from", chat.Sender)
+		reporter.SendMessage(Reported{Type: TypeReported, ID: messageID})
+		return
+	}
+	reporter.reject(TypeReport, "messagenotfound")
+}
+
+func writeReport(report Report) {
+	var out io.Writer = os.Stdout
+	if len(*reportLogPath) > 0 {
+		file, err := os.OpenFile(*reportLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			fmt.Println("Failed to open report log:", err)
+		} else {
+			defer file.Close()
+			out = file
+		}
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		fmt.Println("Failed to serialize report:", err)
+		return
+	}
+	fmt.Fprintf(out, "%s\n", data)
+}
@@ -19,11 +19,12 @@ package game
 
 import (
 	"math/rand"
+	"time"
 )
 
 // Start the game already!
 func (game *Game) Start() {
-	if game.ConnectedPlayers() < 5 {
+	if game.ConnectedPlayers() < game.Settings.MinPlayers {
 		return
 	}
 	for _, p := range game.Players {
@@ -32,16 +33,52 @@ func (game *Game) Start() {
 		}
 	}
 	game.debugln("Starting...")
+	if game.countdown != nil {
+		game.countdown.Stop()
+		game.countdown = nil
+	}
 	game.Started = true
+	game.StartTime = time.Now()
+	game.listingChanged()
+
+	if game.Settings.RandomSeats {
+		game.ShuffleSeats()
+	}
 
-	game.PresidentIndex = r.Intn(len(game.Players))
+	if game.StartingSeat >= 0 {
+		game.PresidentIndex = game.StartingSeat - 1
+	} else {
+		game.PresidentIndex = r.Intn(len(game.Players))
+	}
 	game.debugln("  President index:", game.PresidentIndex)
 
 	game.GiveRoles()
 	game.MapAndSendRoles()
+	game.ApplyVariant()
 
 	game.BroadcastTable()
 	game.NextPresident()
+	game.FirstPresident = game.PresidentIndex
+}
+
+// ApplyVariant changes the deck and table according to the rule variant of the game
+func (game *Game) ApplyVariant() {
+	if game.Settings.Variant != VariantRebalanced {
+		return
+	}
+	switch game.PlayerCount() {
+	case 6:
+		game.debugln("  Rebalanced: Starting with a fascist policy on the table")
+		game.Cards.RemoveCard(CardFascist)
+		game.Cards.TableFascist++
+	case 7:
+		game.debugln("  Rebalanced: Removing a fascist policy from the deck")
+		game.Cards.RemoveCard(CardFascist)
+	case 9:
+		game.debugln("  Rebalanced: Removing two fascist policies from the deck")
+		game.Cards.RemoveCard(CardFascist)
+		game.Cards.RemoveCard(CardFascist)
+	}
 }
 
 // GiveRoles gives everyone roles (but doesn't send them yet)
@@ -83,34 +120,16 @@ func (game *Game) GiveRoles() {
 	}
 }
 
-// MapRoles maps the info about others' roles that must be sent to players with specific roles
-func (game *Game) MapRoles() (toLiberals map[string]Role, toFascists map[string]Role) {
-	toLiberals = make(map[string]Role)
-	toFascists = make(map[string]Role)
-	for _, player := range game.Players {
-		if player == nil {
-			continue
-		}
-		toLiberals[player.Name] = "unknown"
-		toFascists[player.Name] = player.Role
-	}
-	return
-}
-
 // MapAndSendRoles sends a start message to players containing their roles and possibly other players' roles
 func (game *Game) MapAndSendRoles() {
-	toLiberals, toFascists := game.MapRoles()
-	pc := game.PlayerCount()
 	for _, player := range game.Players {
-		if player == nil {
-			continue
-		}
-		if player.Role == RoleLiberal || (pc > 6 && player.Role == RoleHitler) {
-			player.SendMessage(Start{Type: TypeStart, Role: player.Role, Players: toLiberals})
-		} else if player.Role == RoleFascist || (pc < 7 && player.Role == RoleHitler) {
-			player.SendMessage(Start{Type: TypeStart, Role: player.Role, Players: toFascists})
+		if player != nil {
+			player.SendMessage(game.StartMessage(player))
 		}
 	}
+	for _, spectator := range game.Spectators {
+		spectator.SendMessage(game.StartMessage(spectator))
+	}
 }
 
 // NextPresident moves the game to the next president
@@ -118,22 +137,23 @@ func (game *Game) NextPresident() {
 	game.debugln("Moving to next president...")
 	if game.PlayersInGame() < 4 {
 		game.Error("Not enough players left")
+		return
 	}
 	game.PresidentIndex++
-	if game.PresidentIndex >= 10 {
+	if game.PresidentIndex >= len(game.Players) {
 		game.PresidentIndex = 0
 	}
 	if game.Players[game.PresidentIndex] == nil || !game.Players[game.PresidentIndex].Alive {
 		game.NextPresident()
 		return
 	}
-	game.State = ActSelectPresident
+	game.SetState(ActSelectPresident)
 	game.SetPresident(game.Players[game.PresidentIndex])
 }
 
 // SetPresident sets the new president
 func (game *Game) SetPresident(player *Player) {
-	game.State = ActPickChancellor
+	game.SetState(ActPickChancellor)
 	game.PreviousPresident = game.President
 	game.PreviousChancellor = game.Chancellor
 	game.Chancellor = nil
@@ -156,21 +176,39 @@ func (game *Game) SetPresident(player *Player) {
 	game.Broadcast(President{Type: TypePresident, Name: game.President.Name, Unpickable: unpickable})
 }
 
-// PickChancellor is called when the president picks his/her chancellor
-func (game *Game) PickChancellor(name string) {
+// targetRejection gets the reason why the president can't target the given player with an action.
+// An empty string means the target is valid.
+func (game *Game) targetRejection(p *Player) string {
+	if p == nil {
+		return "invalidtarget"
+	} else if !p.Alive {
+		return "deadtarget"
+	} else if p == game.President {
+		return "selftarget"
+	}
+	return ""
+}
+
+// PickChancellor is called when the president picks his/her chancellor.
+// The returned string is the reason the pick was rejected, or empty if it was accepted.
+func (game *Game) PickChancellor(name string) string {
 	p := game.GetPlayer(name)
-	if p != nil && p.Alive && p != game.President && p != game.PreviousChancellor && (game.PlayerCount() == 5 || p != game.PreviousPresident) {
-		game.Chancellor = p
-		game.State = ActVote
-		game.debugln(game.President.Name, "picked", game.Chancellor.Name, "as the chancellor")
-		game.Broadcast(StartVote{Type: TypeStartVote, President: game.President.Name, Chancellor: game.Chancellor.Name})
+	if code := game.targetRejection(p); len(code) > 0 {
+		return code
+	} else if p == game.PreviousChancellor || (game.PlayerCount() > 5 && p == game.PreviousPresident) {
+		return "termlimited"
 	}
+	game.Chancellor = p
+	game.SetState(ActVote)
+	game.debugln(game.President.Name, "picked", game.Chancellor.Name, "as the chancellor")
+	game.Broadcast(StartVote{Type: TypeStartVote, President: game.President.Name, Chancellor: game.Chancellor.Name})
+	return ""
 }
 
 // Vote is called when the player sends a vote command
 func (game *Game) Vote(player *Player, vote string) {
 	player.Vote = ParseVote(vote)
-	player.SendMessage(VoteMessage{Type: TypeVote, Vote: player.Vote})
+	player.SendMessage(VoteMessage{Type: TypeVote, ID: player.request, Vote: player.Vote})
 	game.debugln(player.Name, "voted", player.Vote)
 
 	var ja, nein = game.CalculateVotes()
@@ -235,26 +273,32 @@ func (game *Game) ThreeGovsFailed() {
 func (game *Game) StartDiscard() {
 	if game.Cards.TableFascist >= 3 {
 		if game.Chancellor.Role == RoleHitler {
-			game.End(CardFascist)
+			game.End(CardFascist, EndHitlerElected)
 			return
 		}
 	}
-	game.State = ActDiscardPresident
+	game.SetState(ActDiscardPresident)
 	game.debugln("Started card discarding with", game.President.Name, "and", game.Chancellor.Name)
 	game.FailedGovs = 0
 	game.Broadcast(Discard{Type: TypePresidentDiscard, Name: game.President.Name})
 	game.Discarding = game.Cards.PickCards()
+	game.startGovernment()
 	game.BroadcastTable()
 	game.President.SendMessage(CardsMessage{Type: TypeCards, Cards: game.Discarding})
 }
 
 // DiscardCard is called when the chancellor or president discards a card
-func (game *Game) DiscardCard(card int) {
-	game.VetoRequested = false
+func (game *Game) DiscardCard(card int) string {
 	if card >= len(game.Discarding) || card < 0 {
-		return
+		return "invalidindex"
 	}
+	game.VetoRequested = false
 	game.debugf("A %s card was discarded by the ", game.Discarding[card])
+	if len(game.Discarding) == 3 {
+		game.Stream(Discarded{Type: TypeDiscarded, Name: game.President.Name, Card: game.Discarding[card]})
+	} else {
+		game.Stream(Discarded{Type: TypeDiscarded, Name: game.Chancellor.Name, Card: game.Discarding[card]})
+	}
 	game.Cards.Discarded = append(game.Cards.Discarded, game.Discarding[card])
 	game.Discarding[card] = game.Discarding[len(game.Discarding)-1]
 	game.Discarding = game.Discarding[:len(game.Discarding)-1]
@@ -263,15 +307,18 @@ func (game *Game) DiscardCard(card int) {
 		game.BroadcastTable()
 		game.debugNoPrefix("president\n")
 		game.Broadcast(Discard{Type: TypeChancellorDiscard, Name: game.Chancellor.Name})
+		game.SetState(ActDiscardChancellor)
+		game.CurrentGovernment().Received = copyCards(game.Discarding)
 		game.Chancellor.SendMessage(CardsMessage{Type: TypeCards, Cards: game.Discarding})
-		game.State = ActDiscardChancellor
 	} else if len(game.Discarding) == 1 {
 		game.debugNoPrefix("chancellor\n")
+		game.CurrentGovernment().Enacted = game.Discarding[0]
 		game.Broadcast(Enact{Type: TypeEnact, President: game.President.Name, Chancellor: game.Chancellor.Name, Policy: game.Discarding[0]})
 		game.Enact(game.Discarding[0], false)
 	} else {
 		game.Error("Invalid amount of cards to discard")
 	}
+	return ""
 }
 
 // VetoRequest is called when the chancellor wants to veto the current discard
@@ -292,6 +339,7 @@ func (game *Game) VetoAccept() {
 	}
 	game.BroadcastTable()
 	game.Discarding = []Card{}
+	game.CurrentGovernment().Vetoed = true
 
 	game.GovernmentFailed(true)
 }
@@ -311,10 +359,10 @@ func (game *Game) Enact(card Card, force bool) {
 	}
 	game.BroadcastTable()
 	if game.Cards.TableFascist >= 6 {
-		game.End(CardFascist)
+		game.End(CardFascist, EndFascistPolicies)
 		return
 	} else if game.Cards.TableLiberal >= 5 {
-		game.End(CardLiberal)
+		game.End(CardLiberal, EndLiberalPolicies)
 		return
 	}
 
@@ -332,7 +380,9 @@ func (game *Game) RunSpecialAction() {
 	case ActPolicyPeek:
 		game.debugln(game.President.Name, "will now peek on the next three cards")
 		game.Broadcast(PresidentAction{Type: TypePeekBroadcast, President: game.President.Name})
-		game.President.SendMessage(CardsMessage{Type: TypePeek, Cards: game.Cards.Peek()})
+		peeked := copyCards(game.Cards.Peek())
+		game.addAction(&ExecutiveAction{Type: TypePeekBroadcast, Peeked: peeked})
+		game.President.SendMessage(CardsMessage{Type: TypePeek, Cards: peeked})
 		game.NextPresident()
 	case ActInvestigatePlayer:
 		game.debugln(game.President.Name, "will now investigate a player")
@@ -348,56 +398,70 @@ func (game *Game) RunSpecialAction() {
 		game.NextPresident()
 		return
 	}
-	game.State = act
+	game.SetState(act)
 }
 
 // Investigated is called when the president has investigated a player
-func (game *Game) Investigated(name string) {
+func (game *Game) Investigated(name string) string {
 	p := game.GetPlayer(name)
-	if p != nil {
-		game.debugln(game.President.Name, "investigated", p.Name)
-		game.Broadcast(PresidentActionFinished{Type: TypeInvestigated, President: game.President.Name, Name: p.Name})
-		game.President.SendMessage(InvestigateResult{Type: TypeInvestigateResult, Name: p.Name, Result: p.Role.Card()})
-		game.NextPresident()
+	if code := game.targetRejection(p); len(code) > 0 {
+		return code
 	}
+	game.debugln(game.President.Name, "investigated", p.Name)
+	game.Broadcast(PresidentActionFinished{Type: TypeInvestigated, President: game.President.Name, Name: p.Name})
+	game.addAction(&ExecutiveAction{Type: TypeInvestigate, Target: p.Name, Result: p.Role.Card()})
+	game.President.SendMessage(InvestigateResult{Type: TypeInvestigateResult, Name: p.Name, Result: p.Role.Card()})
+	game.NextPresident()
+	return ""
 }
 
 // SelectedPresident is called when the president selects the next president
-func (game *Game) SelectedPresident(name string) {
+func (game *Game) SelectedPresident(name string) string {
 	p := game.GetPlayer(name)
-	if p != nil && p.Alive && p != game.President {
-		game.debugln(game.President.Name, "selected", p.Name, "as the next president")
-		game.Broadcast(PresidentActionFinished{Type: TypePresidentSelected, President: game.President.Name, Name: p.Name})
-		game.SetPresident(p)
+	if code := game.targetRejection(p); len(code) > 0 {
+		return code
 	}
+	game.debugln(game.President.Name, "selected", p.Name, "as the next president")
+	game.Broadcast(PresidentActionFinished{Type: TypePresidentSelected, President: game.President.Name, Name: p.Name})
+	game.SetPresident(p)
+	return ""
 }
 
 // ExecutedPlayer is called when the president executes a player
-func (game *Game) ExecutedPlayer(name string) {
+func (game *Game) ExecutedPlayer(name string) string {
 	p := game.GetPlayer(name)
-	if p != nil && p.Alive {
-		game.debugln(game.President.Name, "executed", p.Name)
-		game.Broadcast(PresidentActionFinished{Type: TypeExecuted, President: game.President.Name, Name: p.Name})
-		p.Alive = false
-		if p.Role == RoleHitler {
-			game.End(CardLiberal)
-		} else {
-			game.NextPresident()
-		}
+	if code := game.targetRejection(p); len(code) > 0 {
+		return code
+	}
+	game.debugln(game.President.Name, "executed", p.Name)
+	game.Broadcast(PresidentActionFinished{Type: TypeExecuted, President: game.President.Name, Name: p.Name})
+	p.Alive = false
+	game.addAction(&ExecutiveAction{Type: TypeExecute, Target: p.Name})
+	game.BroadcastSeats()
+	if p.Role == RoleHitler {
+		game.End(CardLiberal, EndHitlerExecuted)
+	} else {
+		game.NextPresident()
 	}
+	return ""
 }
 
 func (game *Game) Error(msg string) {
 	game.debugln("Error:", msg)
 	game.Broadcast(Error{Type: TypeError, Message: msg})
+	game.flushStream(true)
 	game.Ended = true
 	Remove(game.Name)
+	if game.Room != nil {
+		game.Room.GameEnded(game, "")
+	}
 }
 
 // End the game with the given winner
-func (game *Game) End(winner Card) {
-	game.debugln(winner, "won")
-	var end = End{Type: TypeEnd, Winner: winner, Roles: make(map[string]Role)}
+func (game *Game) End(winner Card, reason EndReason) {
+	game.debugln(winner, "won by", reason)
+	game.verifyActions()
+	var end = End{Type: TypeEnd, Winner: winner, Reason: reason, Roles: make(map[string]Role), Governments: game.Governments, Actions: game.Actions}
 	for _, player := range game.Players {
 		if player == nil {
 			continue
@@ -405,6 +469,14 @@ func (game *Game) End(winner Card) {
 		end.Roles[player.Name] = player.Role
 	}
 	game.Broadcast(end)
+	game.recordStats(winner, reason)
+	if game.recordRatings(winner) {
+		saveAccounts()
+	}
+	game.flushStream(true)
 	game.Ended = true
 	Remove(game.Name)
+	if game.Room != nil {
+		game.Room.GameEnded(game, winner)
+	}
 }
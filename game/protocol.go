@@ -0,0 +1,144 @@
+// shitlerd - A manager for online Secret Hitler games
+// Copyright (C) 2016-2017 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package game contains the game management code
+package game
+
+import (
+	"reflect"
+	"strings"
+)
+
+// ProtocolVersion is the newest protocol version the server supports.
+// Version 1 is the legacy protocol used by clients that don't send a version.
+const ProtocolVersion = 2
+
+// Capability is an optional protocol feature that a client can ask for
+type Capability string
+
+// The supported capabilities
+const (
+	CapabilityEvents     Capability = "events"
+	CapabilityAck        Capability = "ack"
+	CapabilityClaims     Capability = "claims"
+	CapabilitySpectators Capability = "spectators"
+	CapabilityTimers     Capability = "timers"
+	CapabilitySeats      Capability = "seats"
+)
+
+// Capabilities contains all capabilities the server supports
+var Capabilities = []Capability{CapabilityEvents, CapabilityAck, CapabilityClaims, CapabilitySpectators, CapabilityTimers, CapabilitySeats}
+
+// capabilityTypes maps message types to the capability the client needs to receive them.
+// Message types that aren't listed are sent to every client.
+var capabilityTypes = map[Type]Capability{
+	TypeAck:                CapabilityAck,
+	TypeClaim:              CapabilityClaims,
+	TypeClaimInvestigation: CapabilityClaims,
+	TypeClaimPeek:          CapabilityClaims,
+	TypeSpectators:         CapabilitySpectators,
+	TypeCountdown:          CapabilityTimers,
+	TypeCancelCountdown:    CapabilityTimers,
+	TypeDisconnectWarning:  CapabilityTimers,
+	TypeSeats:              CapabilitySeats,
+}
+
+// Protocol is the protocol version and the capabilities negotiated with a client
+type Protocol struct {
+	Version      int
+	Capabilities map[Capability]bool
+}
+
+// LegacyProtocol returns the protocol used for clients that haven't negotiated anything
+func LegacyProtocol() Protocol {
+	return Protocol{Version: 1, Capabilities: make(map[Capability]bool)}
+}
+
+// Negotiate picks the protocol to use with a client that asked for the given version and capabilities.
+// Clients that ask for version 2 or newer without listing any capabilities get all capabilities.
+func Negotiate(version int, capabilities []string) Protocol {
+	proto := LegacyProtocol()
+	if version <= 1 {
+		return proto
+	} else if version > ProtocolVersion {
+		version = ProtocolVersion
+	}
+	proto.Version = version
+	if len(capabilities) == 0 {
+		for _, capability := range Capabilities {
+			proto.Capabilities[capability] = true
+		}
+		return proto
+	}
+	for _, name := range capabilities {
+		capability := Capability(strings.ToLower(strings.TrimSpace(name)))
+		for _, supported := range Capabilities {
+			if capability == supported {
+				proto.Capabilities[capability] = true
+			}
+		}
+	}
+	return proto
+}
+
+// Has checks if the capability was negotiated
+func (proto Protocol) Has(capability Capability) bool {
+	return proto.Capabilities[capability]
+}
+
+// CapabilityList returns the negotiated capabilities in the same order as Capabilities
+func (proto Protocol) CapabilityList() []Capability {
+	list := []Capability{}
+	for _, capability := range Capabilities {
+		if proto.Has(capability) {
+			list = append(list, capability)
+		}
+	}
+	return list
+}
+
+// Filter adapts an outgoing message to the protocol. Messages whose type needs a capability that
+// wasn't negotiated are dropped and the event fields are removed unless the events capability was negotiated.
+func (proto Protocol) Filter(msg interface{}) (interface{}, bool) {
+	evt, isEvent := msg.(Event)
+	if isEvent && !proto.Has(CapabilityEvents) {
+		msg = evt.Message
+	}
+	typ := MessageType(msg)
+	if isEvent {
+		typ = MessageType(evt.Message)
+	}
+	if capability, ok := capabilityTypes[typ]; ok && !proto.Has(capability) {
+		return nil, false
+	}
+	return msg, true
+}
+
+// MessageType gets the value of the Type field of an outgoing message struct
+func MessageType(msg interface{}) Type {
+	val := reflect.ValueOf(msg)
+	if val.Kind() == reflect.Ptr {
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return ""
+	}
+	field := val.FieldByName("Type")
+	if !field.IsValid() || field.Kind() != reflect.String {
+		return ""
+	}
+	return Type(field.String())
+}
@@ -0,0 +1,199 @@
+// shitlerd - A manager for online Secret Hitler games
+// Copyright (C) 2016-2017 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package game contains the game management code
+package game
+
+import (
+	"time"
+)
+
+const queueCheckInterval = 5 * time.Second
+const queueFallback = 60 * time.Second
+const queueSettle = 15 * time.Second
+
+// QueueEntry is a player waiting in the matchmaking queue
+type QueueEntry struct {
+	Name    string
+	Variant Variant
+	Size    int
+	Joined  time.Time
+	Conn    Connection
+}
+
+// The matchmaking queue is guarded by the game lock like the games themselves.
+var queue []*QueueEntry
+var queueTimer *time.Timer
+var queueWait time.Duration
+
+// accepts checks if the entry can be seated in a game with the given variant and size.
+// Players who have waited longer than the fallback time also accept smaller tables.
+func (entry *QueueEntry) accepts(variant Variant, size int, now time.Time) bool {
+	if len(entry.Variant) > 0 && entry.Variant != variant {
+		return false
+	}
+	return entry.Size == 0 || entry.Size == size || (size < entry.Size && now.Sub(entry.Joined) >= queueFallback)
+}
+
+// Enqueue adds the connection to the matchmaking queue. An empty variant or zero size means any variant or size.
+// The returned string is an error code, or empty if the player was queued.
+func Enqueue(name string, variant Variant, size int, conn Connection) string {
+	if code := CheckName(name); len(code) > 0 {
+		return code
+	} else if !ownsName(conn, name) {
+		return "namereserved"
+	}
+	switch variant {
+	case "", VariantStandard, VariantRebalanced:
+	default:
+		return "invalidvariant"
+	}
+	if size != 0 && (size < 5 || size > 10) {
+		return "invalidsize"
+	}
+	for _, entry := range queue {
+		if entry.Conn != conn && entry.Name == name {
+			return "nameused"
+		}
+	}
+	Dequeue(conn)
+	queue = append(queue, &QueueEntry{Name: name, Variant: variant, Size: size, Joined: time.Now(), Conn: conn})
+	matchQueue()
+	return ""
+}
+
+// Dequeue removes the connection from the matchmaking queue
+func Dequeue(conn Connection) {
+	for i, entry := range queue {
+		if entry.Conn == conn {
+			queue = append(queue[:i], queue[i+1:]...)
+			sendQueueStatus()
+			return
+		}
+	}
+}
+
+// matchQueue starts games for as many groups of queued players as possible and
+// schedules the next check if there are still players waiting.
+func matchQueue() {
+	for {
+		group, variant := findGroup()
+		if group == nil {
+			break
+		}
+		startQueuedGame(group, variant)
+	}
+	sendQueueStatus()
+	if queueTimer != nil {
+		queueTimer.Stop()
+		queueTimer = nil
+	}
+	if len(queue) > 0 {
+		queueTimer = afterFunc(queueCheckInterval, matchQueue)
+	}
+}
+
+// settled checks if the group can be seated at a table of the given size. Groups that could still grow
+// into a larger table are held until nobody has joined them for the settle time.
+func settled(group []*QueueEntry, size int, now time.Time) bool {
+	if size == 10 {
+		return true
+	}
+	newest := group[0].Joined
+	wantsLarger := false
+	for _, entry := range group {
+		if entry.Joined.After(newest) {
+			newest = entry.Joined
+		}
+		if entry.Size == 0 || entry.Size > size {
+			wantsLarger = true
+		}
+	}
+	return !wantsLarger || now.Sub(newest) >= queueSettle
+}
+
+// findGroup finds the largest settled group of queued players that accept the same variant and size.
+// Players who joined the queue first are preferred.
+func findGroup() ([]*QueueEntry, Variant) {
+	now := time.Now()
+	for size := 10; size >= 5; size-- {
+		for _, variant := range []Variant{VariantStandard, VariantRebalanced} {
+			var group []*QueueEntry
+			for _, entry := range queue {
+				if entry.accepts(variant, size, now) {
+					group = append(group, entry)
+					if len(group) == size {
+						if settled(group, size, now) {
+							return group, variant
+						}
+						break
+					}
+				}
+			}
+		}
+	}
+	return nil, ""
+}
+
+// startQueuedGame creates a private game for the given group, seats everyone and starts the game
+func startQueuedGame(group []*QueueEntry, variant Variant) {
+	settings := DefaultSettings()
+	settings.Variant = variant
+	settings.MaxPlayers = len(group)
+	settings.Public = false
+	game := New(settings)
+	game.debugln("Created from the matchmaking queue")
+
+	now := time.Now()
+	for _, entry := range group {
+		Dequeue(entry.Conn)
+		Unsubscribe(entry.Conn)
+		queueWait = (queueWait*3 + now.Sub(entry.Joined)) / 4
+		state, player := game.Join(entry.Name, "", entry.Conn)
+		if player == nil {
+			entry.Conn.SendMessage(Matched{Type: TypeMatched, Game: game.Name, Name: entry.Name, Success: false, Message: state})
+			continue
+		}
+		entry.Conn.SetPlayer(player)
+	}
+	for _, player := range game.Players {
+		if player != nil {
+			player.SendMessage(Matched{Type: TypeMatched, Game: game.Name, Name: player.Name, Success: true, AuthToken: player.AuthToken, Players: game.PlayerStates(), Seats: game.Seats(nil), Settings: game.Settings.WithoutPassword()})
+		}
+	}
+	game.Start()
+}
+
+// queueETA estimates how many more seconds the given entry has to wait based on how long recently matched players waited
+func queueETA(entry *QueueEntry, now time.Time) int {
+	expected := queueWait
+	if expected == 0 {
+		expected = queueFallback
+	}
+	eta := expected - now.Sub(entry.Joined)
+	if eta < 0 {
+		return 0
+	}
+	return int(eta.Seconds())
+}
+
+// sendQueueStatus sends the position and the estimated wait to everyone in the queue
+func sendQueueStatus() {
+	now := time.Now()
+	for i, entry := range queue {
+		entry.Conn.SendMessage(QueueStatus{Type: TypeQueue, Position: i + 1, Queued: len(queue), ETA: queueETA(entry, now)})
+	}
+}
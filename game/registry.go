@@ -18,25 +18,30 @@
 package game
 
 import (
+	"sort"
 	"strings"
+	"time"
 )
 
 var registry map[string]*Game
+var subscribers map[Connection]bool
 
 func init() {
 	registry = make(map[string]*Game)
+	subscribers = make(map[Connection]bool)
 }
 
-// New creates a game and adds it to the registry
-func New() string {
+// New creates a game with the given settings and adds it to the registry
+func New(settings Settings) *Game {
 	name := RandomName()
 	lcName := strings.ToLower(name)
 	if game, ok := registry[lcName]; ok && game != nil && !game.Ended {
 		name = RandomName()
 	}
-	game := CreateGame(name)
+	game := CreateGame(name, settings)
 	registry[lcName] = game
-	return name
+	game.listingChanged()
+	return game
 }
 
 // Get the game with the given name from the registry
@@ -53,5 +58,83 @@ func Remove(name string) bool {
 		return false
 	}
 	registry[name] = nil
+	sendListing()
 	return true
 }
+
+// List gets info about all public games in the registry and the current games of all public rooms.
+// Room games are listed with the name of the room, as that's what clients join with.
+func List() []GameInfo {
+	games := []GameInfo{}
+	for _, game := range registry {
+		if game == nil || game.Ended || !game.Settings.Public {
+			continue
+		}
+		games = append(games, game.Info())
+	}
+	for _, room := range rooms {
+		if room.Game == nil || room.Game.Ended || !room.Settings.Public {
+			continue
+		}
+		info := room.Game.Info()
+		info.Name = room.Name
+		info.Room = true
+		games = append(games, info)
+	}
+	sort.Slice(games, func(i, j int) bool {
+		return games[i].Waiting > games[j].Waiting
+	})
+	return games
+}
+
+// Subscribe the given connection to changes in the list of public games.
+// The current list is sent immediately.
+func Subscribe(conn Connection) {
+	subscribers[conn] = true
+	conn.SendMessage(Games{Type: TypeGames, Games: List()})
+}
+
+// Unsubscribe the given connection from changes in the list of public games
+func Unsubscribe(conn Connection) {
+	delete(subscribers, conn)
+}
+
+func sendListing() {
+	if len(subscribers) == 0 {
+		return
+	}
+	msg := Games{Type: TypeGames, Games: List()}
+	for conn := range subscribers {
+		conn.SendMessage(msg)
+	}
+}
+
+// Info creates a GameInfo object describing this game for game listings
+func (game *Game) Info() GameInfo {
+	info := GameInfo{
+		Name:       game.Name,
+		Players:    game.PlayerCount(),
+		MinPlayers: game.Settings.MinPlayers,
+		MaxPlayers: game.Settings.MaxPlayers,
+		Variant:    game.Settings.Variant,
+		Started:    game.Started,
+		Locked:     game.Locked,
+		Spectators: len(game.Spectators),
+	}
+	// The waiting time stops when the game starts
+	if game.Started {
+		info.Waiting = int(game.StartTime.Sub(game.Created).Seconds())
+	} else {
+		info.Waiting = int(time.Since(game.Created).Seconds())
+	}
+	if game.Host != nil {
+		info.Host = game.Host.Name
+	}
+	return info
+}
+
+func (game *Game) listingChanged() {
+	if game.Settings.Public {
+		sendListing()
+	}
+}
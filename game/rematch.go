@@ -0,0 +1,100 @@
+// shitlerd - A manager for online Secret Hitler games
+// Copyright (C) 2016-2017 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package game contains the game management code
+package game
+
+import (
+	"time"
+)
+
+// RequestRematch is called when a player of an ended game wants to play again with the same table.
+// The first request creates the new game, after which every request moves the player into it.
+func (game *Game) RequestRematch(player *Player) {
+	if !game.Ended || player.Conn == nil || game.Room != nil {
+		return
+	}
+	if game.Rematch == nil {
+		game.CreateRematch(player)
+	}
+	game.Rematch.MoveIn(player, player == game.Host)
+}
+
+// CreateRematch creates a new game with the same settings and reserves a seat in it for every connected player.
+// The starting seat is rotated by one and players who don't opt in before the rematch timer runs out lose their seat.
+func (game *Game) CreateRematch(requester *Player) {
+	rematch := New(game.Settings)
+	rematch.StartingSeat = (game.FirstPresident + 1) % len(rematch.Players)
+	for i, player := range game.Players {
+		if player == nil || !player.Connected || i >= len(rematch.Players) {
+			continue
+		}
+		rematch.Players[i] = &Player{Name: player.Name, AuthToken: rematch.createAuthToken(), Account: player.Account, Alive: true, Vote: VoteEmpty, Game: rematch}
+		rematch.rematchPending[player.Name] = true
+	}
+	game.Rematch = rematch
+	game.debugln(requester.Name, "requested a rematch, created", rematch.Name)
+	game.Broadcast(RematchMessage{Type: TypeRematch, Name: requester.Name, Game: rematch.Name, Timeout: rematch.Settings.Timers.Rematch})
+	afterFunc(time.Duration(rematch.Settings.Timers.Rematch)*time.Second, rematch.dropRematchPending)
+}
+
+// MoveIn moves the connection of a player from the previous game into the seat reserved for them in this game.
+// If no seat was reserved, the player joins normally.
+func (game *Game) MoveIn(old *Player, host bool) {
+	conn := old.Conn
+	player := game.GetPlayer(old.Name)
+	if player == nil || !game.rematchPending[old.Name] {
+		state, p := game.Join(old.Name, "", conn)
+		if p == nil {
+			conn.SendMessage(Rematched{Type: TypeRematched, Game: game.Name, Name: old.Name, Success: false, Message: state})
+			return
+		}
+		player = p
+	} else {
+		delete(game.rematchPending, old.Name)
+		player.Conn = conn
+		player.Connected = true
+		game.Broadcast(JoinPart{Type: TypeJoin, Name: player.Name})
+		game.debugln(player.Name, "joined the rematch")
+		if game.Host == nil {
+			game.SetHost(player)
+		}
+		game.listingChanged()
+	}
+	if host && game.Host != player {
+		game.SetHost(player)
+	}
+
+	old.Conn = nil
+	old.Connected = false
+	conn.SetPlayer(player)
+	msg := Rematched{Type: TypeRematched, Game: game.Name, Name: player.Name, Success: true, AuthToken: player.AuthToken, Players: game.PlayerStates(), Settings: game.Settings.WithoutPassword()}
+	if game.Host != nil {
+		msg.Host = game.Host.Name
+	}
+	player.SendMessage(msg)
+}
+
+func (game *Game) dropRematchPending() {
+	if game.Started {
+		return
+	}
+	for name := range game.rematchPending {
+		game.debugln(name, "didn't join the rematch in time")
+		game.Leave(name)
+	}
+	game.rematchPending = make(map[string]bool)
+}
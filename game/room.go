@@ -0,0 +1,166 @@
+// shitlerd - A manager for online Secret Hitler games
+// Copyright (C) 2016-2017 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package game contains the game management code
+package game
+
+import (
+	"fmt"
+	"strings"
+)
+
+const roomChatHistory = 50
+
+var rooms map[string]*Room
+
+func init() {
+	rooms = make(map[string]*Room)
+}
+
+// Room is a persistent table that hosts one game after another
+type Room struct {
+	Name        string
+	Settings    Settings
+	Host        string
+	HostToken   string
+	StreamToken string
+	Members     map[string]bool
+	Chat        []Chat
+	Scoreboard  map[string]*Score
+	Game        *Game
+	Games       int
+
+	// The lock and bans of the room carry over to every game in it
+	Locked      bool
+	BannedNames map[string]bool
+	BannedAddrs map[string]bool
+}
+
+// Score contains the results of a single member of a room across all games played in the room
+type Score struct {
+	Games       int `json:"games"`
+	Wins        int `json:"wins"`
+	LiberalWins int `json:"liberalWins"`
+	FascistWins int `json:"fascistWins"`
+}
+
+// NewRoom creates a room with the given settings, adds it to the room registry and creates the first game in it
+func NewRoom(settings Settings) *Room {
+	name := RandomName()
+	if _, ok := rooms[strings.ToLower(name)]; ok {
+		name = RandomName()
+	}
+	settings.Normalize()
+	room := &Room{
+		Name:        name,
+		Settings:    settings,
+		HostToken:   createInviteSecret(),
+		StreamToken: createInviteSecret(),
+		Members:     make(map[string]bool),
+		Chat:        []Chat{},
+		Scoreboard:  make(map[string]*Score),
+		BannedNames: make(map[string]bool),
+		BannedAddrs: make(map[string]bool),
+	}
+	room.NextGame()
+	rooms[strings.ToLower(name)] = room
+	room.Game.listingChanged()
+	return room
+}
+
+// GetRoom gets the room with the given name from the room registry
+func GetRoom(name string) (*Room, bool) {
+	room, ok := rooms[strings.ToLower(name)]
+	return room, ok
+}
+
+// NextGame creates the next game in this room with the settings of the room
+func (room *Room) NextGame() *Game {
+	room.Games++
+	game := CreateGame(fmt.Sprintf("%s#%d", room.Name, room.Games), room.Settings)
+	game.Room = room
+	game.HostToken = room.HostToken
+	game.StreamToken = room.StreamToken
+	game.BannedNames = room.BannedNames
+	game.BannedAddrs = room.BannedAddrs
+	if room.Game != nil {
+		game.StartingSeat = (room.Game.FirstPresident + 1) % len(game.Players)
+	}
+	room.Game = game
+	return game
+}
+
+// GameEnded is called when a game in this room ends. The scoreboard is updated if there is a winner,
+// and every connected player is moved into the next game.
+func (room *Room) GameEnded(game *Game, winner Card) {
+	if game != room.Game {
+		return
+	}
+	if len(winner) > 0 {
+		for _, player := range game.Players {
+			if player == nil {
+				continue
+			}
+			score, ok := room.Scoreboard[player.Name]
+			if !ok {
+				score = &Score{}
+				room.Scoreboard[player.Name] = score
+			}
+			score.Games++
+			if player.Role.Card() == winner {
+				score.Wins++
+				if winner == CardLiberal {
+					score.LiberalWins++
+				} else {
+					score.FascistWins++
+				}
+			}
+		}
+	}
+
+	host := room.Host
+	next := room.NextGame()
+	for _, player := range game.Players {
+		if player != nil && player.Connected && player.Conn != nil {
+			next.MoveIn(player, player.Name == host)
+		}
+	}
+	// The lock is only applied after moving the players, as it would keep them out of the next game.
+	next.Locked = room.Locked
+	next.Broadcast(Scoreboard{Type: TypeScoreboard, Scores: room.Scoreboard})
+}
+
+// AddMember adds the given name to the members of this room
+func (room *Room) AddMember(name string) {
+	room.Members[name] = true
+}
+
+// MemberList returns the names of all members of this room
+func (room *Room) MemberList() []string {
+	members := make([]string, 0, len(room.Members))
+	for name := range room.Members {
+		members = append(members, name)
+	}
+	return members
+}
+
+// AddChat stores a chat message in the chat history of the room
+func (room *Room) AddChat(chat Chat) {
+	room.Chat = append(room.Chat, chat)
+	if len(room.Chat) > roomChatHistory {
+		room.Chat = room.Chat[len(room.Chat)-roomChatHistory:]
+	}
+}
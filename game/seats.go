@@ -0,0 +1,87 @@
+// shitlerd - A manager for online Secret Hitler games
+// Copyright (C) 2016-2017 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package game contains the game management code
+package game
+
+// Seat is a single occupied seat at the table. The presidency moves in seat index order.
+type Seat struct {
+	Index     int    `json:"index"`
+	Name      string `json:"name"`
+	Connected bool   `json:"connected"`
+	Ready     bool   `json:"ready"`
+	Alive     bool   `json:"alive"`
+	Role      Role   `json:"role,omitempty"`
+}
+
+// Seats lists the occupied seats in order. If roles is not nil, the role of each player is taken from it.
+func (game *Game) Seats(roles map[string]Role) []Seat {
+	seats := []Seat{}
+	for i, player := range game.Players {
+		if player == nil {
+			continue
+		}
+		seat := Seat{Index: i, Name: player.Name, Connected: player.Connected, Ready: player.Ready, Alive: player.Alive}
+		if roles != nil {
+			seat.Role = roles[player.Name]
+		}
+		seats = append(seats, seat)
+	}
+	return seats
+}
+
+// BroadcastSeats tells everyone the current seating order
+func (game *Game) BroadcastSeats() {
+	game.Broadcast(Seats{Type: TypeSeats, Seats: game.Seats(nil)})
+}
+
+// ShuffleSeats randomizes the seating order and moves everyone to the first seats
+func (game *Game) ShuffleSeats() {
+	players := []*Player{}
+	for _, player := range game.Players {
+		if player != nil {
+			players = append(players, player)
+		}
+	}
+	for i := range players {
+		j := r.Intn(i + 1)
+		players[i], players[j] = players[j], players[i]
+	}
+	for i := range game.Players {
+		if i < len(players) {
+			game.Players[i] = players[i]
+		} else {
+			game.Players[i] = nil
+		}
+	}
+	game.debugln("Seats were shuffled")
+	game.BroadcastSeats()
+}
+
+// MoveSeat moves the given player to the given seat. If the seat is occupied, the players swap seats.
+func (game *Game) MoveSeat(name string, index int) {
+	if game.Started || index < 0 || index >= len(game.Players) {
+		return
+	}
+	for i, player := range game.Players {
+		if player != nil && player.Name == name {
+			game.Players[i], game.Players[index] = game.Players[index], game.Players[i]
+			game.debugln(player.Name, "was moved to seat", index)
+			game.BroadcastSeats()
+			return
+		}
+	}
+}
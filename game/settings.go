@@ -0,0 +1,106 @@
+// shitlerd - A manager for online Secret Hitler games
+// Copyright (C) 2016-2017 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package game contains the game management code
+package game
+
+// Settings contains the options a game was created with
+type Settings struct {
+	MaxPlayers         int      `json:"maxPlayers"`
+	MinPlayers         int      `json:"minPlayers"`
+	Public             bool     `json:"public"`
+	Password           string   `json:"password,omitempty"`
+	Timers             Timers   `json:"timers"`
+	Variant            Variant  `json:"variant"`
+	AllowSpectators    bool     `json:"allowSpectators"`
+	SpectatorGhostChat bool     `json:"spectatorGhostChat"`
+	RandomSeats        bool     `json:"randomSeats"`
+	AutoStart          bool     `json:"autoStart"`
+	ChatLock           ChatLock `json:"chatLock"`
+
+	StreamDelay       int `json:"streamDelay"`
+	StreamDelayEvents int `json:"streamDelayEvents"`
+}
+
+// Timers contains the time limits of a game in seconds.
+// Zero limits fall back to their defaults.
+type Timers struct {
+	Rematch    int `json:"rematch"`
+	Countdown  int `json:"countdown"`
+	Disconnect int `json:"disconnect"`
+}
+
+// DefaultSettings returns the settings used when the creator doesn't specify anything
+func DefaultSettings() Settings {
+	return Settings{
+		MaxPlayers:      10,
+		MinPlayers:      5,
+		Public:          true,
+		Timers:          Timers{Rematch: 60, Countdown: 10, Disconnect: 30},
+		Variant:         VariantStandard,
+		AllowSpectators: true,
+		StreamDelay:     60,
+	}
+}
+
+// Normalize makes sure the settings are within the allowed limits.
+// Private games without a password are given a random invite secret as the password.
+func (settings *Settings) Normalize() {
+	if settings.MaxPlayers < 5 || settings.MaxPlayers > 10 {
+		settings.MaxPlayers = 10
+	}
+	if settings.MinPlayers < 5 {
+		settings.MinPlayers = 5
+	} else if settings.MinPlayers > settings.MaxPlayers {
+		settings.MinPlayers = settings.MaxPlayers
+	}
+	if settings.Timers.Rematch <= 0 {
+		settings.Timers.Rematch = 60
+	}
+	if settings.Timers.Countdown <= 0 {
+		settings.Timers.Countdown = 10
+	}
+	if settings.Timers.Disconnect <= 0 {
+		settings.Timers.Disconnect = 30
+	}
+	if settings.StreamDelayEvents < 0 {
+		settings.StreamDelayEvents = 0
+	}
+	if settings.StreamDelay <= 0 && settings.StreamDelayEvents == 0 {
+		settings.StreamDelay = 60
+	}
+	switch settings.ChatLock {
+	case ChatLockOff, ChatLockAll, ChatLockGovernment:
+	default:
+		settings.ChatLock = ChatLockOff
+	}
+	switch settings.Variant {
+	case VariantStandard, VariantRebalanced:
+	default:
+		settings.Variant = VariantStandard
+	}
+	if len(settings.Password) > 0 {
+		settings.Public = false
+	} else if !settings.Public {
+		settings.Password = createInviteSecret()
+	}
+}
+
+// WithoutPassword returns a copy of the settings with the password removed
+func (settings Settings) WithoutPassword() Settings {
+	settings.Password = ""
+	return settings
+}
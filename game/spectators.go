@@ -0,0 +1,124 @@
+// shitlerd - A manager for online Secret Hitler games
+// Copyright (C) 2016-2017 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package game contains the game management code
+package game
+
+// Spectate adds the given connection as a spectator. Spectators don't have a seat or a role and only receive public events.
+// If a valid stream token is given, the spectator is added to the delayed omniscient stream instead.
+func (game *Game) Spectate(name, authtoken, streamtoken string, conn Connection) (interface{}, *Player) {
+	if !game.Settings.AllowSpectators && len(streamtoken) == 0 {
+		return "nospectators", nil
+	} else if !validName(name) {
+		return "invalidname", nil
+	} else if !filterName(name) {
+		return "nameblocked", nil
+	} else if !ownsName(conn, name) {
+		return "namereserved", nil
+	} else if game.GetPlayer(name) != nil {
+		return "nameused", nil
+	}
+	if spectator := game.GetSpectator(name); spectator != nil {
+		if !spectator.ownedBy(authtoken, conn) {
+			return "nameused", nil
+		}
+		oldConn := spectator.Conn
+		spectator.Conn = conn
+		if oldConn != nil {
+			oldConn.SendMessage("connected-other")
+			oldConn.Close()
+		}
+		if spectator.Omniscient {
+			game.StartStream(spectator)
+		}
+		return 0, spectator
+	}
+	if game.IsBanned(name, conn) {
+		return "banned", nil
+	}
+	spectator := &Player{Name: name, AuthToken: game.createAuthToken(), Account: accountFor(conn, name), Connected: true, Spectator: true, Conn: conn, Game: game}
+	if len(streamtoken) > 0 {
+		if !game.CheckStreamToken(streamtoken) {
+			return "wrongstreamtoken", nil
+		}
+		game.AddStreamer(spectator)
+		return 0, spectator
+	}
+	game.Spectators = append(game.Spectators, spectator)
+	game.debugln(name, "started spectating")
+	game.BroadcastSpectatorCount()
+	return 0, spectator
+}
+
+// GetSpectator gets the spectator or streamer with the given name
+func (game *Game) GetSpectator(name string) *Player {
+	for _, spectator := range append(game.Spectators, game.Streamers...) {
+		if spectator.Name == name {
+			return spectator
+		}
+	}
+	return nil
+}
+
+// RemoveSpectator removes the given spectator or streamer from the game
+func (game *Game) RemoveSpectator(spectator *Player) {
+	for i, s := range game.Streamers {
+		if s == spectator {
+			game.Streamers = append(game.Streamers[:i], game.Streamers[i+1:]...)
+			game.debugln(spectator.Name, "stopped watching the omniscient stream")
+			return
+		}
+	}
+	for i, s := range game.Spectators {
+		if s == spectator {
+			game.Spectators = append(game.Spectators[:i], game.Spectators[i+1:]...)
+			game.debugln(spectator.Name, "stopped spectating")
+			game.BroadcastSpectatorCount()
+			return
+		}
+	}
+}
+
+// BroadcastSpectators sends a message to all spectators. Streamers receive messages through the omniscient stream instead.
+func (game *Game) BroadcastSpectators(msg interface{}) {
+	game.broadcastSpectators(msg, Event{})
+}
+
+func (game *Game) broadcastSpectators(msg interface{}, evt Event) {
+	for _, spectator := range game.Spectators {
+		spectator.sendEvent(msg, evt)
+	}
+}
+
+// BroadcastSpectatorCount tells everyone how many spectators are watching the game
+func (game *Game) BroadcastSpectatorCount() {
+	game.Broadcast(SpectatorCount{Type: TypeSpectators, Count: len(game.Spectators)})
+	game.listingChanged()
+}
+
+// ReceiveSpectatorMessage is called from ReceiveMessage when the sender is a spectator.
+func (player *Player) ReceiveSpectatorMessage(msg Message) {
+	if msg.Type == TypeChat {
+		player.Chat(msg.Message.String())
+	} else if msg.Type == TypeReport {
+		player.ReceiveReport(msg)
+	} else if msg.Type == TypePart {
+		player.Game.RemoveSpectator(player)
+		player.detach()
+	} else {
+		player.Reject(msg, "notallowed")
+	}
+}
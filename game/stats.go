@@ -0,0 +1,106 @@
+// shitlerd - A manager for online Secret Hitler games
+// Copyright (C) 2016-2017 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package game contains the game management code
+package game
+
+import (
+	"fmt"
+	"time"
+
+	"maunium.net/go/shitlerd/accounts"
+)
+
+// recordStats stores the result of the game in the account of every registered player
+func (game *Game) recordStats(winner Card, reason EndReason) {
+	count := game.PlayerCount()
+	for _, player := range game.Players {
+		if player == nil || player.Account == nil {
+			continue
+		}
+		record := accounts.GameRecord{
+			Game:    game.Name,
+			Time:    time.Now(),
+			Players: count,
+			Role:    string(player.Role),
+			Won:     player.Role.Card() == winner,
+			Reason:  string(reason),
+		}
+		for _, gov := range game.Governments {
+			if gov.President != player.Name && gov.Chancellor != player.Name {
+				continue
+			}
+			if gov.President == player.Name {
+				record.President++
+			} else {
+				record.Chancellor++
+			}
+			switch gov.Enacted {
+			case CardLiberal:
+				record.LiberalPolicies++
+			case CardFascist:
+				record.FascistPolicies++
+			}
+		}
+		for _, act := range game.Actions {
+			if act.Type == TypeExecute && act.Target == player.Name {
+				record.WasExecuted = true
+			}
+			if act.President != player.Name {
+				continue
+			}
+			switch act.Type {
+			case TypeExecute:
+				record.Executed = append(record.Executed, act.Target)
+			case TypeInvestigate:
+				record.Investigated = append(record.Investigated, act.Target)
+			}
+		}
+		player.Account.AddGame(record)
+	}
+}
+
+// recordRatings updates the ratings of all registered players. It returns false if nobody was registered.
+func (game *Game) recordRatings(winner Card) bool {
+	var liberals, fascists []*accounts.Account
+	registered := false
+	for _, player := range game.Players {
+		if player == nil {
+			continue
+		}
+		registered = registered || player.Account != nil
+		if player.Role.Card() == CardLiberal {
+			liberals = append(liberals, player.Account)
+		} else {
+			fascists = append(fascists, player.Account)
+		}
+	}
+	if !registered {
+		return false
+	}
+	accounts.RateGame(liberals, fascists, winner == CardLiberal)
+	return true
+}
+
+// saveAccounts saves the accounts in the background, as writing the account file shouldn't hold the game lock
+func saveAccounts() {
+	go func() {
+		err := accounts.Save()
+		if err != nil {
+			fmt.Println("Failed to save accounts:", err)
+		}
+	}()
+}
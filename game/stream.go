@@ -0,0 +1,138 @@
+// shitlerd - A manager for online Secret Hitler games
+// Copyright (C) 2016-2017 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package game contains the game management code
+package game
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"time"
+)
+
+// streamEvent is a queued stream event. If snapshot is set, the event is the stream start snapshot of a single streamer.
+type streamEvent struct {
+	queued   time.Time
+	msg      StreamEvent
+	snapshot json.RawMessage
+	streamer *Player
+}
+
+// CheckStreamToken checks if the given token allows watching the omniscient stream of the game
+func (game *Game) CheckStreamToken(token string) bool {
+	return len(token) > 0 && subtle.ConstantTimeCompare([]byte(game.StreamToken), []byte(token)) == 1
+}
+
+// AddStreamer adds an omniscient spectator. Streamers see everything that happens in the game,
+// but only after the delay set in the game settings.
+func (game *Game) AddStreamer(spectator *Player) {
+	spectator.Omniscient = true
+	game.Streamers = append(game.Streamers, spectator)
+	game.debugln(spectator.Name, "started watching the omniscient stream")
+	game.StartStream(spectator)
+}
+
+// StartStream queues a snapshot of the current state of the game for the given streamer. The snapshot is delayed
+// like all other events, and the streamer doesn't receive any events before it, as the snapshot already contains them.
+func (game *Game) StartStream(streamer *Player) {
+	roles := game.VisibleRoles(streamer)
+	start := StreamStart{Type: TypeStreamStart, Delay: game.Settings.StreamDelay, DelayEvents: game.Settings.StreamDelayEvents, Started: game.Started, Roles: roles, Seats: game.Seats(roles)}
+	if game.Started {
+		table := game.GetTable()
+		start.Table = &table
+		// The streamer sees the real hands and results, as the snapshot is delayed like everything else.
+		start.Governments = game.Governments
+		start.Actions = game.Actions
+	}
+	data, err := json.Marshal(start)
+	if err != nil {
+		game.debugln("Failed to serialize stream snapshot:", err)
+		return
+	}
+	streamer.streamReady = false
+	game.queueStream(streamEvent{queued: time.Now(), snapshot: data, streamer: streamer})
+}
+
+// Stream adds a public event to the omniscient stream
+func (game *Game) Stream(msg interface{}) {
+	game.stream("", msg)
+}
+
+// StreamPrivate adds an event sent only to the given player to the omniscient stream
+func (game *Game) StreamPrivate(player *Player, msg interface{}) {
+	game.stream(player.Name, msg)
+}
+
+func (game *Game) stream(to string, msg interface{}) {
+	if len(game.Streamers) == 0 {
+		return
+	}
+	// The message is serialized immediately, as the game may modify the values in it before the delay runs out.
+	data, err := json.Marshal(msg)
+	if err != nil {
+		game.debugln("Failed to serialize stream event:", err)
+		return
+	}
+	now := time.Now()
+	game.queueStream(streamEvent{queued: now, msg: StreamEvent{Type: TypeStream, Time: now.Unix(), To: to, Event: data}})
+}
+
+// queueStream adds an event to the stream queue and schedules sending it once the delay has passed.
+// Once the game has ended, the queue is sent without delay.
+func (game *Game) queueStream(evt streamEvent) {
+	game.streamQueue = append(game.streamQueue, evt)
+	game.flushStream(game.Ended)
+	if game.Settings.StreamDelay > 0 && !game.Ended {
+		afterFunc(time.Duration(game.Settings.StreamDelay)*time.Second, func() {
+			game.flushStream(false)
+		})
+	}
+}
+
+// flushStream sends all events whose delay has passed to the streamers. If all is true, the delay is ignored.
+func (game *Game) flushStream(all bool) {
+	delay := time.Duration(game.Settings.StreamDelay) * time.Second
+	for len(game.streamQueue) > 0 {
+		evt := game.streamQueue[0]
+		if !all && (time.Since(evt.queued) < delay || game.queuedStreamEvents(evt) < game.Settings.StreamDelayEvents) {
+			return
+		}
+		game.streamQueue = game.streamQueue[1:]
+		if evt.streamer != nil {
+			evt.streamer.streamReady = true
+			evt.streamer.send(evt.snapshot)
+			continue
+		}
+		for _, streamer := range game.Streamers {
+			if streamer.streamReady {
+				streamer.send(evt.msg)
+			}
+		}
+	}
+}
+
+// queuedStreamEvents counts the game events queued after the first queued event. Snapshots don't count towards the event delay.
+func (game *Game) queuedStreamEvents(first streamEvent) (n int) {
+	for _, evt := range game.streamQueue {
+		if evt.streamer == nil {
+			n++
+		}
+	}
+	if first.streamer == nil {
+		n--
+	}
+	return
+}
@@ -147,3 +147,23 @@ const (
 	RoleFascist Role = "fascist"
 	RoleHitler  Role = "hitler"
 )
+
+// Variant is a set of game rules
+type Variant string
+
+// The possible rule variants
+const (
+	VariantStandard   Variant = "standard"
+	VariantRebalanced Variant = "rebalanced"
+)
+
+// EndReason is the way a game was won
+type EndReason string
+
+// The possible ways to win a game
+const (
+	EndLiberalPolicies EndReason = "liberalpolicies"
+	EndFascistPolicies EndReason = "fascistpolicies"
+	EndHitlerExecuted  EndReason = "hitlerexecuted"
+	EndHitlerElected   EndReason = "hitlerelected"
+)
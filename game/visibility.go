@@ -0,0 +1,132 @@
+// shitlerd - A manager for online Secret Hitler games
+// Copyright (C) 2016-2017 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package game contains the game management code
+package game
+
+// Audience is the kind of recipient a message is being sent to. It decides what hidden information the recipient may see.
+type Audience string
+
+// The possible audiences
+const (
+	AudienceLiberal    Audience = "liberal"
+	AudienceFascist    Audience = "fascist"
+	AudienceHitler     Audience = "hitler"
+	AudienceDead       Audience = "dead"
+	AudienceSpectator  Audience = "spectator"
+	AudienceOmniscient Audience = "omniscient"
+)
+
+// RoleUnknown is shown in place of roles the recipient isn't allowed to see
+const RoleUnknown Role = "unknown"
+
+// AudienceOf gets the audience of the given recipient. A nil recipient means the omniscient stream.
+func (game *Game) AudienceOf(player *Player) Audience {
+	switch {
+	case player == nil || player.Omniscient:
+		return AudienceOmniscient
+	case player.Spectator:
+		return AudienceSpectator
+	case game.Started && !player.Alive:
+		return AudienceDead
+	}
+	switch player.Role {
+	case RoleFascist:
+		return AudienceFascist
+	case RoleHitler:
+		return AudienceHitler
+	default:
+		return AudienceLiberal
+	}
+}
+
+// KnowsRoles checks if the given recipient is allowed to know the roles of all players.
+// Fascists always know the roles, and Hitler only knows them in games with less than 7 players.
+func (game *Game) KnowsRoles(player *Player) bool {
+	if player == nil || player.Omniscient {
+		return true
+	} else if player.Spectator {
+		return false
+	}
+	switch player.Role {
+	case RoleFascist:
+		return true
+	case RoleHitler:
+		return game.PlayerCount() < 7
+	default:
+		return false
+	}
+}
+
+// VisibleRoles maps the names of all players to the roles the given recipient is allowed to see
+func (game *Game) VisibleRoles(player *Player) map[string]Role {
+	knows := game.KnowsRoles(player)
+	roles := make(map[string]Role)
+	for _, p := range game.Players {
+		if p == nil {
+			continue
+		} else if knows || game.Ended {
+			roles[p.Name] = p.Role
+		} else {
+			roles[p.Name] = RoleUnknown
+		}
+	}
+	return roles
+}
+
+// StartMessage creates the start message for the given recipient
+func (game *Game) StartMessage(player *Player) Start {
+	roles := game.VisibleRoles(player)
+	start := Start{Type: TypeStart, Players: roles, Seats: game.Seats(roles)}
+	if player != nil && !player.Spectator {
+		start.Role = player.Role
+	}
+	return start
+}
+
+// Visible decides whether or not the given recipient may see the given message and redacts it if necessary.
+// Every message sent to a player or spectator goes through this function. A nil recipient means the omniscient stream.
+func (game *Game) Visible(player *Player, msg interface{}) (interface{}, bool) {
+	audience := game.AudienceOf(player)
+	if audience == AudienceOmniscient {
+		return msg, true
+	}
+	switch evt := msg.(type) {
+	case Start:
+		return game.StartMessage(player), true
+	case CardsMessage:
+		switch evt.Type {
+		case TypeCards:
+			return msg, (player == game.President && game.State == ActDiscardPresident) ||
+				(player == game.Chancellor && game.State == ActDiscardChancellor)
+		case TypePeek:
+			return msg, player == game.President
+		}
+		return msg, false
+	case InvestigateResult:
+		return msg, player == game.President
+	case Discarded, StreamStart, StreamEvent:
+		return msg, false
+	case Chat:
+		switch evt.Channel {
+		case ChannelGhost:
+			return msg, audience == AudienceDead || (audience == AudienceSpectator && game.Settings.SpectatorGhostChat)
+		case ChannelSpectator:
+			return msg, audience == AudienceSpectator
+		}
+	}
+	return msg, true
+}
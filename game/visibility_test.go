@@ -0,0 +1,179 @@
+// shitlerd - A manager for online Secret Hitler games
+// Copyright (C) 2016-2017 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package game contains the game management code
+package game
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"maunium.net/go/shitlerd/accounts"
+)
+
+// recorder is a connection that records the types of the messages it receives.
+// Chat messages are recorded with their channel and stream events with the type of the wrapped event.
+type recorder struct {
+	received map[string]bool
+}
+
+type recordedMessage struct {
+	Type    Type            `json:"type"`
+	Channel Channel         `json:"channel"`
+	Event   json.RawMessage `json:"event"`
+}
+
+func (rec *recorder) SendMessage(msg interface{}) {
+	data, _ := json.Marshal(msg)
+	var parsed recordedMessage
+	json.Unmarshal(data, &parsed)
+	if parsed.Type == TypeStream {
+		var inner recordedMessage
+		json.Unmarshal(parsed.Event, &inner)
+		parsed = inner
+	}
+	key := string(parsed.Type)
+	if len(parsed.Channel) > 0 {
+		key += ":" + string(parsed.Channel)
+	}
+	rec.received[key] = true
+}
+
+func (rec *recorder) RemoteAddr() string         { return "127.0.0.1" }
+func (rec *recorder) SetPlayer(player *Player)   {}
+func (rec *recorder) Account() *accounts.Account { return nil }
+func (rec *recorder) Close()                     {}
+
+// visibilityGame creates a started game where the president is discarding. The returned map contains
+// a recipient for every audience, keyed by the name of the recipient.
+func visibilityGame(players int, ghostChat bool) (*Game, map[string]*Player) {
+	settings := DefaultSettings()
+	settings.MaxPlayers = players
+	settings.AllowSpectators = true
+	settings.SpectatorGhostChat = ghostChat
+	game := CreateGame("visibility", settings)
+	game.Settings.StreamDelay = 0
+	game.Settings.StreamDelayEvents = 0
+
+	recipients := make(map[string]*Player)
+	add := func(name string, role Role) *Player {
+		player := &Player{Name: name, Role: role, Alive: true, Connected: true, Conn: &recorder{received: make(map[string]bool)}, Game: game}
+		recipients[name] = player
+		return player
+	}
+	seated := []*Player{
+		add("president", RoleLiberal),
+		add("chancellor", RoleLiberal),
+		add("liberal", RoleLiberal),
+		add("fascist", RoleFascist),
+		add("hitler", RoleHitler),
+		add("dead", RoleLiberal),
+	}
+	for i := len(seated); i < players; i++ {
+		seated = append(seated, &Player{Name: "filler" + strconv.Itoa(i), Role: RoleLiberal, Alive: true, Game: game})
+	}
+	copy(game.Players, seated)
+	recipients["dead"].Alive = false
+
+	spectator := add("spectator", "")
+	spectator.Spectator = true
+	game.Spectators = append(game.Spectators, spectator)
+	streamer := add("streamer", "")
+	streamer.Spectator = true
+	game.AddStreamer(streamer)
+
+	game.Started = true
+	game.State = ActDiscardPresident
+	game.President = recipients["president"]
+	game.Chancellor = recipients["chancellor"]
+	for _, player := range recipients {
+		player.Conn.(*recorder).received = make(map[string]bool)
+	}
+	return game, recipients
+}
+
+var hiddenMessages = []interface{}{
+	CardsMessage{Type: TypeCards, Cards: []Card{CardLiberal, CardFascist, CardFascist}},
+	CardsMessage{Type: TypePeek, Cards: []Card{CardFascist, CardFascist, CardLiberal}},
+	InvestigateResult{Type: TypeInvestigateResult, Name: "fascist", Result: CardFascist},
+	Discarded{Type: TypeDiscarded, Name: "president", Card: CardLiberal},
+	StreamStart{Type: TypeStreamStart},
+	StreamEvent{Type: TypeStream, Event: json.RawMessage(`{"type":"table"}`)},
+	Chat{Type: TypeChat, Channel: ChannelGhost, Sender: "dead", Message: "boo"},
+	Chat{Type: TypeChat, Channel: ChannelSpectator, Sender: "spectator", Message: "hi"},
+}
+
+func TestVisibility(t *testing.T) {
+	tests := []struct {
+		name       string
+		players    int
+		ghostChat  bool
+		recipient  string
+		audience   Audience
+		receives   []string
+		knowsRoles bool
+	}{
+		{name: "liberal", players: 7, recipient: "liberal", audience: AudienceLiberal},
+		{name: "president", players: 7, recipient: "president", audience: AudienceLiberal,
+			receives: []string{"cards", "peekcards", "investigateresult"}},
+		{name: "chancellor", players: 7, recipient: "chancellor", audience: AudienceLiberal},
+		{name: "fascist", players: 7, recipient: "fascist", audience: AudienceFascist, knowsRoles: true},
+		{name: "hitler with 5-6 players", players: 6, recipient: "hitler", audience: AudienceHitler, knowsRoles: true},
+		{name: "hitler with 7+ players", players: 7, recipient: "hitler", audience: AudienceHitler},
+		{name: "dead", players: 7, recipient: "dead", audience: AudienceDead,
+			receives: []string{"chat:ghost"}},
+		{name: "spectator", players: 7, recipient: "spectator", audience: AudienceSpectator,
+			receives: []string{"chat:spectator"}},
+		{name: "spectator with ghost chat", players: 7, ghostChat: true, recipient: "spectator", audience: AudienceSpectator,
+			receives: []string{"chat:ghost", "chat:spectator"}},
+		{name: "omniscient streamer", players: 7, recipient: "streamer", audience: AudienceOmniscient, knowsRoles: true,
+			receives: []string{"cards", "peekcards", "investigateresult", "discarded", "streamstart", "stream", "chat:ghost", "chat:spectator"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			game, recipients := visibilityGame(test.players, test.ghostChat)
+			player := recipients[test.recipient]
+			if audience := game.AudienceOf(player); audience != test.audience {
+				t.Fatalf("audience is %s, expected %s", audience, test.audience)
+			}
+			for _, msg := range hiddenMessages {
+				game.Broadcast(msg)
+			}
+
+			received := player.Conn.(*recorder).received
+			expected := make(map[string]bool)
+			for _, key := range test.receives {
+				expected[key] = true
+				if !received[key] {
+					t.Errorf("didn't receive %s", key)
+				}
+			}
+			for key := range received {
+				if !expected[key] {
+					t.Errorf("received %s", key)
+				}
+			}
+
+			roles := game.StartMessage(player).Players
+			if known := roles["fascist"] == RoleFascist; known != test.knowsRoles {
+				t.Errorf("knows roles: %t, expected %t", known, test.knowsRoles)
+			} else if !known && roles["fascist"] != RoleUnknown {
+				t.Errorf("hidden role shown as %s", roles["fascist"])
+			}
+		})
+	}
+}
@@ -19,7 +19,8 @@ import (
 	"flag"
 	"fmt"
 
-	_ "maunium.net/go/shitlerd/game"
+	"maunium.net/go/shitlerd/accounts"
+	"maunium.net/go/shitlerd/game"
 	"maunium.net/go/shitlerd/web"
 )
 
@@ -28,5 +29,13 @@ var port = flag.Int("port", 29305, "The port to bind the web server to.")
 
 func main() {
 	flag.Parse()
+	err := game.LoadFilter()
+	if err != nil {
+		panic(err)
+	}
+	err = accounts.Load()
+	if err != nil {
+		panic(err)
+	}
 	web.Load(fmt.Sprintf("%s:%d", *address, *port))
 }
@@ -18,13 +18,17 @@
 package web
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
+	rtdebug "runtime/debug"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"maunium.net/go/shitlerd/accounts"
 	"maunium.net/go/shitlerd/game"
 )
 
@@ -34,31 +38,85 @@ const (
 	writeWait      = 5 * time.Second
 	pongWait       = 10 * time.Second
 	pingPeriod     = 5 * time.Second
-	maxMessageSize = 1024
+	maxMessageSize = 4096
+	sendBufferSize = 256
 )
 
+const subprotocolPrefix = "shitlerd.v"
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  2048,
 	WriteBufferSize: 2048,
+	Subprotocols:    []string{fmt.Sprintf("%s%d", subprotocolPrefix, game.ProtocolVersion), subprotocolPrefix + "1"},
 }
 
 type connection struct {
-	ws *websocket.Conn
-	ch chan interface{}
-	p  *game.Player
+	ws      *websocket.Conn
+	ch      chan interface{}
+	done    chan struct{}
+	p       *game.Player
+	account *accounts.Account
+	proto   game.Protocol
+
+	// Messages sent while the connection is joining a game are held until the join response has been sent
+	joining bool
+	held    []interface{}
 }
 
 func (c *connection) SendMessage(msg interface{}) {
-	c.ch <- msg
+	msg, ok := c.proto.Filter(msg)
+	if !ok {
+		return
+	} else if c.joining {
+		c.held = append(c.held, msg)
+		return
+	}
+	c.send(msg)
+}
+
+// send queues a message for the write pump without blocking, as the caller usually holds the game lock.
+// Messages sent after the write pump has stopped are dropped. If the client doesn't read its messages
+// fast enough and the buffer fills up, the connection is closed, which disconnects the player.
+func (c *connection) send(msg interface{}) {
+	select {
+	case <-c.done:
+	case c.ch <- msg:
+	default:
+		fmt.Println("Send buffer full, closing connection to", c.RemoteAddr())
+		c.ws.Close()
+	}
+}
+
+// closeMessage tells the write pump to send a close message and stop
+type closeMessage struct{}
+
+func (c *connection) RemoteAddr() string {
+	host, _, err := net.SplitHostPort(c.ws.RemoteAddr().String())
+	if err != nil {
+		return c.ws.RemoteAddr().String()
+	}
+	return host
+}
+
+func (c *connection) SetPlayer(p *game.Player) {
+	c.p = p
+}
+
+func (c *connection) Account() *accounts.Account {
+	return c.account
 }
 
 func (c *connection) Close() {
-	c.write(websocket.CloseMessage, []byte{})
+	c.send(closeMessage{})
 	c.p = nil
 }
 
 func (c *connection) readPump() {
 	defer func() {
+		game.Lock()
+		game.Unsubscribe(c)
+		game.Dequeue(c)
+		game.Unlock()
 		c.ws.Close()
 	}()
 	for {
@@ -66,29 +124,94 @@ func (c *connection) readPump() {
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
 				fmt.Println("Unexpected close:", err)
-				if c.p != nil && c.p.Conn == c {
-					c.p.Disconnect()
-					c.p = nil
-				}
+				c.disconnect()
 			}
 			break
 		}
 
-		var data = make(map[string]interface{})
-		err = json.Unmarshal(message, &data)
-		if err != nil {
-			fmt.Println(err)
-			continue
+		c.handle(message)
+	}
+}
+
+// handle decodes and handles a single message from the client. Panics are recovered so that
+// a single bad message can't take down the server. If the message contained an ID, it's echoed
+// in the rejection or acknowledgement.
+func (c *connection) handle(data []byte) {
+	msg, code := game.ParseMessage(data)
+	var login loginAttempt
+	if len(code) == 0 && (msg.Type == game.TypeJoin || msg.Type == game.TypeQueue) {
+		login = tryLogin(msg)
+	}
+	game.Lock()
+	defer game.Unlock()
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Printf("Panic while handling %s message: %v\n%s", msg.Type, err, rtdebug.Stack())
+			c.SendMessage(game.Rejected{Type: game.TypeRejected, ID: msg.ID, Code: "internalerror", Request: msg.Type})
 		}
+	}()
+	if len(code) > 0 {
+		if *debug {
+			fmt.Println("Rejected message:", code, string(data))
+		}
+		c.SendMessage(game.Rejected{Type: game.TypeRejected, ID: msg.ID, Code: code, Request: msg.Type})
+		return
+	}
 
-		if c.p == nil {
-			if data["type"] == "join" {
-				c.ch <- c.join(data)
-			}
-			continue
+	if c.p != nil {
+		if c.p.ReceiveMessage(msg) {
+			c.ack(msg)
+		}
+		return
+	}
+	if len(msg.Version) > 0 && (msg.Type == game.TypeJoin || msg.Type == game.TypeQueue) {
+		version, _ := msg.Version.Int()
+		capabilities := []string{}
+		if len(msg.Capabilities) > 0 {
+			capabilities = strings.Split(msg.Capabilities.String(), ",")
 		}
+		c.proto = game.Negotiate(version, capabilities)
+	}
+	switch msg.Type {
+	case game.TypeJoin:
+		c.joinGame(msg, login)
+		if c.p != nil {
+			game.Unsubscribe(c)
+			game.Dequeue(c)
+		}
+		return
+	case game.TypeGames:
+		game.Subscribe(c)
+	case game.TypeUnsubscribe:
+		game.Unsubscribe(c)
+	case game.TypeQueue:
+		code = c.queue(msg, login)
+	case game.TypeLeaveQueue:
+		game.Dequeue(c)
+	default:
+		code = "notjoined"
+	}
+	if len(code) > 0 {
+		c.SendMessage(game.Rejected{Type: game.TypeRejected, ID: msg.ID, Code: code, Request: msg.Type})
+	} else {
+		c.ack(msg)
+	}
+}
+
+// disconnect disconnects the player of this connection, unless the player has already moved to another connection
+func (c *connection) disconnect() {
+	game.Lock()
+	defer game.Unlock()
+	if c.p != nil && c.p.Conn == c {
+		c.p.Disconnect()
+		c.p = nil
+	}
+}
 
-		c.p.ReceiveMessage(data)
+// ack acknowledges a handled message if the client gave it an ID
+func (c *connection) ack(msg game.Message) {
+	if len(msg.ID) > 0 {
+		c.SendMessage(game.Ack{Type: game.TypeAck, ID: msg.ID, Request: msg.Type})
 	}
 }
 
@@ -106,85 +229,133 @@ func (c *connection) writePump() {
 	ticker := time.NewTicker(pingPeriod)
 	defer func() {
 		ticker.Stop()
+		close(c.done)
 		c.ws.Close()
+		c.disconnect()
 	}()
 
 	for {
 		select {
-		case new, ok := <-c.ch:
-			if !ok {
+		case new := <-c.ch:
+			if _, ok := new.(closeMessage); ok {
 				c.write(websocket.CloseMessage, []byte{})
-				if c.p != nil && c.p.Conn == c {
-					c.p.Disconnect()
-					c.p = nil
-				}
 				return
 			}
 			err := c.writeJSON(new)
 			if err != nil {
 				fmt.Println("Disconnected:", err)
-				if c.p != nil && c.p.Conn == c {
-					c.p.Disconnect()
-					c.p = nil
-				}
 				return
 			}
 		case <-ticker.C:
 			err := c.write(websocket.PingMessage, []byte{})
 			if err != nil {
-				if c.p != nil && c.p.Conn == c {
-					c.p.Disconnect()
-					c.p = nil
-				}
 				return
 			}
 		}
 	}
 }
 
-func (c *connection) join(data map[string]interface{}) (response map[string]interface{}) {
+// joinGame handles a join message. Messages the game sends to the connection while it's joining are held
+// until the join response has been sent, so that the response is always the first message about the game.
+func (c *connection) joinGame(msg game.Message, login loginAttempt) {
+	c.joining = true
+	defer func() {
+		c.joining = false
+		for _, held := range c.held {
+			c.send(held)
+		}
+		c.held = nil
+	}()
+	c.send(c.join(msg, login))
+}
+
+func (c *connection) join(msg game.Message, login loginAttempt) (response map[string]interface{}) {
 	response = make(map[string]interface{})
-	g, ok := game.Get(data["game"].(string))
-	if !ok || g == nil {
+	if len(msg.ID) > 0 {
+		response["id"] = msg.ID
+	}
+	if c.proto.Version > 1 {
+		response["version"] = c.proto.Version
+		response["capabilities"] = c.proto.CapabilityList()
+	}
+	g, room := findGame(msg)
+	if g == nil {
 		response["success"] = false
 		response["message"] = "gamenotfound"
-		response["game"] = data["game"]
-		response["name"] = data["name"]
+		response["game"] = msg.Game
+		response["name"] = msg.Name
 		return
 	}
 
 	response["game"] = g.Name
-	authtoken, _ := data["authtoken"].(string)
+	if !g.CheckPassword(msg.Password.String()) {
+		response["success"] = false
+		response["message"] = "wrongpassword"
+		response["name"] = msg.Name
+		return
+	}
+	if err := c.login(login); err != nil {
+		response["success"] = false
+		response["message"] = err.Error()
+		response["name"] = msg.Name
+		return
+	}
+	name := msg.Name.String()
+	authtoken := msg.AuthToken.String()
 
-	state, p := g.Join(data["name"].(string), authtoken, c)
+	var state interface{}
+	var p *game.Player
+	if msg.Spectate == "true" {
+		state, p = g.Spectate(name, authtoken, msg.StreamToken.String(), c)
+	} else {
+		state, p = g.Join(name, authtoken, c)
+	}
 	if p != nil {
 		response["name"] = p.Name
 	} else {
-		response["name"] = data["name"]
+		response["name"] = msg.Name
 	}
 
 	if _, isInt := state.(int); isInt {
 		c.p = p
+		if !p.Spectator {
+			g.ClaimHost(p, msg.HostToken.String())
+		}
 		response["success"] = true
+		response["spectator"] = p.Spectator
+		response["spectators"] = len(g.Spectators)
 		response["authtoken"] = p.AuthToken
-		players := make(map[string]bool)
-		for _, p := range g.Players {
-			if p != nil {
-				players[p.Name] = p.Connected
-			}
+		if p.Account != nil {
+			response["account"] = p.Account.Name
+		}
+		if g.Host != nil {
+			response["host"] = g.Host.Name
+		}
+		response["settings"] = g.Settings.WithoutPassword()
+		response["players"] = g.PlayerStates()
+		if room != nil {
+			response["room"] = room.Name
+			response["members"] = room.MemberList()
+			response["scoreboard"] = room.Scoreboard
+			response["history"] = room.Chat
 		}
-		response["players"] = players
 		response["started"] = g.Started
-		if g.Started {
+		if p.Omniscient {
+			response["omniscient"] = true
+		}
+		// The omniscient stream gets the state of the game in the delayed streamstart message instead.
+		roles := g.VisibleRoles(p)
+		if g.Started && !p.Omniscient {
 			response["table"] = g.GetTable()
-			response["role"] = p.Role
-			toLiberals, toFascists := g.MapRoles()
-			pc := g.PlayerCount()
-			if p.Role == game.RoleLiberal || (pc > 6 && p.Role == game.RoleHitler) {
-				response["players"] = toLiberals
-			} else if p.Role == game.RoleFascist || (pc < 7 && p.Role == game.RoleHitler) {
-				response["players"] = toFascists
+			response["governments"] = g.PublicGovernments()
+			response["actions"] = g.PublicActions()
+			response["players"] = roles
+			response["seats"] = g.Seats(roles)
+			if !p.Spectator {
+				response["role"] = p.Role
 			}
+		} else {
+			response["seats"] = g.Seats(nil)
 		}
 	} else {
 		response["success"] = false
@@ -193,6 +364,70 @@ func (c *connection) join(data map[string]interface{}) (response map[string]inte
 	return
 }
 
+// queue adds the connection to the matchmaking queue. The returned string is the error code, or empty if the connection was queued.
+func (c *connection) queue(msg game.Message, login loginAttempt) string {
+	if err := c.login(login); err != nil {
+		return err.Error()
+	}
+	size := 0
+	if len(msg.Size) > 0 {
+		var ok bool
+		size, ok = msg.Size.Int()
+		if !ok {
+			size = -1
+		}
+	}
+	return game.Enqueue(msg.Name.String(), game.Variant(msg.Variant), size, c)
+}
+
+// loginAttempt is the result of checking the account credentials in a join or queue message
+type loginAttempt struct {
+	account *accounts.Account
+	err     error
+}
+
+// tryLogin checks the account credentials given in the message, if any.
+// It's called before locking the game state, as checking a password is slow.
+func tryLogin(msg game.Message) (login loginAttempt) {
+	if len(msg.AccountToken) > 0 {
+		login.account, login.err = accounts.LoginToken(msg.Name.String(), msg.AccountToken.String())
+	} else if len(msg.AccountPassword) > 0 {
+		login.account, login.err = accounts.Login(msg.Name.String(), msg.AccountPassword.String())
+	}
+	return
+}
+
+// login logs the connection in to the account of the login attempt, if the message contained credentials.
+// Once logged in, the account stays attached to the connection.
+func (c *connection) login(login loginAttempt) error {
+	if login.account != nil || login.err != nil {
+		c.account = login.account
+	}
+	return login.err
+}
+
+// findGame finds the game the join message targets. If the message contains a room name or the game name
+// is the name of a room, the current game in the room is returned along with the room.
+func findGame(msg game.Message) (*game.Game, *game.Room) {
+	if len(msg.Room) > 0 {
+		room, ok := game.GetRoom(msg.Room.String())
+		if !ok {
+			return nil, nil
+		}
+		return room.Game, room
+	}
+	name := msg.Game.String()
+	g, ok := game.Get(name)
+	if ok && g != nil {
+		return g, nil
+	}
+	room, ok := game.GetRoom(name)
+	if !ok {
+		return nil, nil
+	}
+	return room.Game, room
+}
+
 func serveWs(w http.ResponseWriter, r *http.Request) {
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -200,7 +435,10 @@ func serveWs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c := &connection{ws: ws, ch: make(chan interface{})}
+	c := &connection{ws: ws, ch: make(chan interface{}, sendBufferSize), done: make(chan struct{}), proto: game.LegacyProtocol()}
+	if version, err := strconv.Atoi(strings.TrimPrefix(ws.Subprotocol(), subprotocolPrefix)); err == nil {
+		c.proto = game.Negotiate(version, nil)
+	}
 	c.ws.SetReadLimit(maxMessageSize)
 	c.ws.SetReadDeadline(time.Now().Add(pongWait))
 	c.ws.SetPongHandler(func(string) error { c.ws.SetReadDeadline(time.Now().Add(pongWait)); return nil })
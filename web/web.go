@@ -18,10 +18,14 @@
 package web
 
 import (
+	"encoding/json"
 	"flag"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gorilla/context"
+	"maunium.net/go/shitlerd/accounts"
 	"maunium.net/go/shitlerd/game"
 )
 
@@ -35,6 +39,12 @@ func Load(addr string) {
 		}
 	}
 	http.HandleFunc("/create", create)
+	http.HandleFunc("/createroom", createRoom)
+	http.HandleFunc("/games", games)
+	http.HandleFunc("/register", register)
+	http.HandleFunc("/login", login)
+	http.HandleFunc("/players/", playerStats)
+	http.HandleFunc("/leaderboard", leaderboard)
 	http.HandleFunc("/socket", serveWs)
 	err := http.ListenAndServe(addr, context.ClearHandler(http.DefaultServeMux))
 	if err != nil {
@@ -42,6 +52,167 @@ func Load(addr string) {
 	}
 }
 
+// CreateResponse is the response to a POST request to /create or /createroom
+type CreateResponse struct {
+	Name        string        `json:"name"`
+	HostToken   string        `json:"hosttoken"`
+	StreamToken string        `json:"streamtoken"`
+	Settings    game.Settings `json:"settings"`
+}
+
 func create(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte(game.New()))
+	settings, ok := readSettings(w, r)
+	if !ok {
+		return
+	}
+	game.Lock()
+	g := game.New(settings)
+	resp := CreateResponse{Name: g.Name, HostToken: g.HostToken, StreamToken: g.StreamToken, Settings: g.Settings}
+	game.Unlock()
+	writeCreated(w, r, resp)
+}
+
+func createRoom(w http.ResponseWriter, r *http.Request) {
+	settings, ok := readSettings(w, r)
+	if !ok {
+		return
+	}
+	game.Lock()
+	room := game.NewRoom(settings)
+	resp := CreateResponse{Name: room.Name, HostToken: room.HostToken, StreamToken: room.StreamToken, Settings: room.Settings}
+	game.Unlock()
+	writeCreated(w, r, resp)
+}
+
+func readSettings(w http.ResponseWriter, r *http.Request) (game.Settings, bool) {
+	settings := game.DefaultSettings()
+	if r.Method == http.MethodPost {
+		err := json.NewDecoder(r.Body).Decode(&settings)
+		if err != nil {
+			http.Error(w, "Invalid settings: "+err.Error(), http.StatusBadRequest)
+			return settings, false
+		}
+		return settings, true
+	}
+	settings.Password = r.URL.Query().Get("password")
+	settings.Public = len(settings.Password) == 0 && r.URL.Query().Get("private") != "true"
+	return settings, true
+}
+
+func writeCreated(w http.ResponseWriter, r *http.Request, resp CreateResponse) {
+	if r.Method == http.MethodPost {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if !resp.Settings.Public {
+		w.Header().Set("X-Game-Password", resp.Settings.Password)
+	}
+	w.Header().Set("X-Host-Token", resp.HostToken)
+	w.Header().Set("X-Stream-Token", resp.StreamToken)
+	w.Write([]byte(resp.Name))
+}
+
+func games(w http.ResponseWriter, r *http.Request) {
+	game.Lock()
+	list := game.List()
+	game.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// AccountRequest is the body of a POST request to /register or /login
+type AccountRequest struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+// AccountResponse is the response to a request to /register or /login
+type AccountResponse struct {
+	Success bool   `json:"success"`
+	Name    string `json:"name,omitempty"`
+	Token   string `json:"token,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func register(w http.ResponseWriter, r *http.Request) {
+	req, ok := readAccountRequest(w, r)
+	if !ok {
+		return
+	}
+	if code := game.CheckName(req.Name); len(code) > 0 {
+		writeAccount(w, http.StatusBadRequest, AccountResponse{Message: code})
+		return
+	}
+	account, err := accounts.Register(req.Name, req.Password)
+	if err == accounts.ErrNameTaken {
+		writeAccount(w, http.StatusConflict, AccountResponse{Message: err.Error()})
+	} else if err == accounts.ErrPasswordTooShort || err == accounts.ErrPasswordTooLong {
+		writeAccount(w, http.StatusBadRequest, AccountResponse{Message: err.Error()})
+	} else if err != nil {
+		writeAccount(w, http.StatusInternalServerError, AccountResponse{Message: "internalerror"})
+	} else {
+		writeAccount(w, http.StatusOK, AccountResponse{Success: true, Name: account.Name, Token: account.Token})
+	}
+}
+
+func login(w http.ResponseWriter, r *http.Request) {
+	req, ok := readAccountRequest(w, r)
+	if !ok {
+		return
+	}
+	account, err := accounts.Login(req.Name, req.Password)
+	if err != nil {
+		writeAccount(w, http.StatusUnauthorized, AccountResponse{Message: err.Error()})
+		return
+	}
+	writeAccount(w, http.StatusOK, AccountResponse{Success: true, Name: account.Name, Token: account.Token})
+}
+
+func readAccountRequest(w http.ResponseWriter, r *http.Request) (req AccountRequest, ok bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	return req, true
+}
+
+func writeAccount(w http.ResponseWriter, status int, resp AccountResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// playerStats handles GET requests to /players/{name}/stats
+func playerStats(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/players/"), "/")
+	if len(parts) != 2 || parts[1] != "stats" {
+		http.NotFound(w, r)
+		return
+	} else if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	account := accounts.Get(parts[0])
+	if account == nil {
+		http.Error(w, "Player not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(account.Stats())
+}
+
+// leaderboard handles GET requests to /leaderboard
+func leaderboard(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 100
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(accounts.Leaderboard(r.URL.Query().Get("by"), limit))
 }